@@ -0,0 +1,1055 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type kind int
+
+const (
+	kindInt kind = iota
+	kindUint
+	kindByte
+	kindBool
+	kindFloat32
+	kindFloat64
+	kindString
+	kindStruct
+	kindPtr
+	kindSlice
+	kindArray
+)
+
+// typ is the resolved type of field.
+type typ struct {
+	kind kind
+
+	// name is the type expression in generated code
+	name string
+
+	// size of number
+	size int
+
+	// len of array
+	len int
+
+	elem *typ
+}
+
+var basicTypes = map[string]typ{
+	"int":     {kind: kindInt, size: 8},
+	"int8":    {kind: kindInt, size: 1},
+	"int16":   {kind: kindInt, size: 2},
+	"int32":   {kind: kindInt, size: 4},
+	"int64":   {kind: kindInt, size: 8},
+	"rune":    {kind: kindInt, size: 4},
+	"uint":    {kind: kindUint, size: 8},
+	"uint16":  {kind: kindUint, size: 2},
+	"uint32":  {kind: kindUint, size: 4},
+	"uint64":  {kind: kindUint, size: 8},
+	"uint8":   {kind: kindByte, size: 1},
+	"byte":    {kind: kindByte, size: 1},
+	"bool":    {kind: kindBool, size: 1},
+	"float32": {kind: kindFloat32, size: 4},
+	"float64": {kind: kindFloat64, size: 8},
+	"string":  {kind: kindString},
+}
+
+// externalTypes are the known types of other packages.
+var externalTypes = map[string]string{
+	"net.HardwareAddr": "[]byte",
+	"net.IP":           "[]byte",
+}
+
+// field is the struct field with parsed tags.
+type field struct {
+	name string
+	t    *typ
+
+	e    string
+	size int
+	num  int
+
+	// size and byte order of length prefix
+	prefix int
+	pe     string
+}
+
+// generator generates methods of struct types.
+type generator struct {
+	buf bytes.Buffer
+
+	specs   map[string]*ast.TypeSpec
+	imports map[string]string
+
+	// methods are names of methods of types declared in the package
+	methods map[string]map[string]bool
+
+	// registered are types with codecs registered by stob.RegisterCodec in the package
+	registered map[string]bool
+
+	// used are the packages used by generated code
+	used map[string]bool
+
+	queue []string
+	done  map[string]bool
+}
+
+// Generate returns source of methods of struct types names declared in files of one package.
+func Generate(files []*ast.File, names []string) ([]byte, error) {
+	g := &generator{
+		specs:      make(map[string]*ast.TypeSpec),
+		imports:    make(map[string]string),
+		methods:    make(map[string]map[string]bool),
+		registered: make(map[string]bool),
+		used:       make(map[string]bool),
+		done:       make(map[string]bool),
+	}
+
+	for _, f := range files {
+		for _, imp := range f.Imports {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			name := path[strings.LastIndex(path, "/")+1:]
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+			g.imports[name] = path
+		}
+
+		ast.Inspect(f, g.inspectRegistered)
+
+		for _, decl := range f.Decls {
+			if fd, ok := decl.(*ast.FuncDecl); ok && fd.Recv != nil && len(fd.Recv.List) == 1 {
+				g.addMethod(fd.Recv.List[0].Type, fd.Name.Name)
+				continue
+			}
+
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				if ts, ok := spec.(*ast.TypeSpec); ok {
+					g.specs[ts.Name.Name] = ts
+				}
+			}
+		}
+	}
+
+	for _, name := range names {
+		g.queueType(strings.TrimSpace(name))
+	}
+
+	var body bytes.Buffer
+	for len(g.queue) > 0 {
+		name := g.queue[0]
+		g.queue = g.queue[1:]
+
+		g.buf.Reset()
+		if err := g.generate(name); err != nil {
+			return nil, err
+		}
+		body.Write(g.buf.Bytes())
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by stobgen; DO NOT EDIT.\n\npackage %s\n\nimport (\n", files[0].Name.Name)
+
+	var paths []string
+	for path := range g.used {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		fmt.Fprintf(&out, "\t%q\n", path)
+	}
+	out.WriteString("\n\t\"github.com/sg3des/stob\"\n)\n")
+	out.Write(body.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %s\n%s", err, out.Bytes())
+	}
+
+	return src, nil
+}
+
+// addMethod records method name of receiver type expr.
+func (g *generator) addMethod(expr ast.Expr, name string) {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	id, ok := expr.(*ast.Ident)
+	if !ok {
+		return
+	}
+
+	if g.methods[id.Name] == nil {
+		g.methods[id.Name] = make(map[string]bool)
+	}
+	g.methods[id.Name][name] = true
+}
+
+// inspectRegistered records types of calls RegisterCodec(reflect.TypeOf(T{}), ...).
+func (g *generator) inspectRegistered(n ast.Node) bool {
+	call, ok := n.(*ast.CallExpr)
+	if !ok || len(call.Args) == 0 {
+		return true
+	}
+
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "RegisterCodec" {
+		return true
+	}
+
+	ast.Inspect(call.Args[0], func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.CompositeLit:
+			g.registered[typeName(x.Type)] = true
+		case *ast.CallExpr:
+			if p, ok := x.Fun.(*ast.ParenExpr); ok {
+				// conversion T(0) or (*T)(nil)
+				if star, ok := p.X.(*ast.StarExpr); ok {
+					g.registered[typeName(star.X)] = true
+				} else {
+					g.registered[typeName(p.X)] = true
+				}
+			} else if name := typeName(x.Fun); name != "" && name != "reflect.TypeOf" {
+				g.registered[name] = true
+			}
+		}
+		return true
+	})
+
+	return true
+}
+
+// typeName returns name of type expression T or pkg.T, or empty string.
+func typeName(expr ast.Expr) string {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		return x.Name
+	case *ast.SelectorExpr:
+		if pkg, ok := x.X.(*ast.Ident); ok {
+			return pkg.Name + "." + x.Sel.Name
+		}
+	}
+	return ""
+}
+
+// ownEncoding reports whether values of type name are encoded by reflection codec in its own way: by registered codec, by Read and Write methods of stob.Reader and stob.Writer, or by standard binary methods of both directions.
+func (g *generator) ownEncoding(name string) bool {
+	if g.registered[name] {
+		return true
+	}
+
+	m := g.methods[name]
+	if m["Size"] && (m["Read"] || m["Write"]) {
+		return true
+	}
+
+	return (m["MarshalBinary"] || m["AppendBinary"] || m["WriteTo"]) && (m["UnmarshalBinary"] || m["ReadFrom"])
+}
+
+// queueType queues struct type name for generation.
+func (g *generator) queueType(name string) {
+	if !g.done[name] {
+		g.done[name] = true
+		g.queue = append(g.queue, name)
+	}
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// generate generates methods of struct type name.
+func (g *generator) generate(name string) error {
+	ts, ok := g.specs[name]
+	if !ok {
+		return fmt.Errorf("type %s is not found", name)
+	}
+
+	st, ok := ts.Type.(*ast.StructType)
+	if !ok {
+		return fmt.Errorf("type %s is not a struct", name)
+	}
+
+	fields, err := g.fields(name, st)
+	if err != nil {
+		return err
+	}
+
+	g.printf("\n// MarshalStob appends encoded %s to b.\n", name)
+	g.printf("func (x *%s) MarshalStob(b []byte) ([]byte, error) {\n", name)
+	for _, f := range fields {
+		if f.nested() {
+			g.printf("var err error\n")
+			break
+		}
+	}
+	for _, f := range fields {
+		g.printf("\n// %s\n", f.name)
+		g.encodeField(f)
+	}
+	g.printf("\nreturn b, nil\n}\n")
+
+	g.printf("\n// UnmarshalStob decodes %s from p, returns count of decoded bytes.\n", name)
+	g.printf("func (x *%s) UnmarshalStob(p []byte) (int, error) {\n", name)
+	g.printf("var n int\n")
+	for _, f := range fields {
+		g.printf("\n// %s\n", f.name)
+		g.decodeField(f)
+	}
+	g.printf("\nreturn n, nil\n}\n")
+
+	g.printf("\n// StobSize returns length of encoded %s in bytes.\n", name)
+	g.printf("func (x *%s) StobSize() int {\n", name)
+	g.printf("var n int\n")
+	for _, f := range fields {
+		g.sizeField(f)
+	}
+	g.printf("return n\n}\n")
+
+	return nil
+}
+
+// fields returns encoded fields of struct type name.
+func (g *generator) fields(name string, st *ast.StructType) ([]*field, error) {
+	var fields []*field
+
+	for _, af := range st.Fields.List {
+		var tag reflect.StructTag
+		if af.Tag != nil {
+			s, _ := strconv.Unquote(af.Tag.Value)
+			tag = reflect.StructTag(s)
+		}
+
+		if tag.Get("stob") == "-" {
+			continue
+		}
+
+		if _, ok := tag.Lookup("pack"); ok {
+			return nil, fmt.Errorf("%s: pack tag is not supported", name)
+		}
+
+		names := af.Names
+		if len(names) == 0 {
+			// embedded field is named by its type
+			expr := af.Type
+			if star, ok := expr.(*ast.StarExpr); ok {
+				expr = star.X
+			}
+			id, ok := expr.(*ast.Ident)
+			if !ok || !ast.IsExported(id.Name) {
+				return nil, fmt.Errorf("%s: unsupported embedded field", name)
+			}
+			names = []*ast.Ident{id}
+		}
+
+		for _, id := range names {
+			if id.Name == "_" && tag.Get("stob") == "unexported" {
+				return nil, fmt.Errorf("%s: unexported fields are not supported", name)
+			}
+			if id.Name == "_" && zeroSize(af.Type) {
+				continue
+			}
+			if id.Name == "_" {
+				return nil, fmt.Errorf("%s: blank field is not supported", name)
+			}
+			if !ast.IsExported(id.Name) {
+				continue
+			}
+
+			f, err := g.field(id.Name, af.Type, tag)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %s", name, id.Name, err)
+			}
+
+			fields = append(fields, f)
+		}
+	}
+
+	for i, f := range fields {
+		if f.unbounded() && i < len(fields)-1 {
+			return nil, fmt.Errorf("%s.%s takes all remaining bytes, but it is not the last field", name, f.name)
+		}
+	}
+
+	return fields, nil
+}
+
+// zeroSize reports whether type is empty struct or array of zero length, blank fields of such types are not encoded.
+func zeroSize(expr ast.Expr) bool {
+	switch t := expr.(type) {
+	case *ast.StructType:
+		return t.Fields == nil || len(t.Fields.List) == 0
+	case *ast.ArrayType:
+		l, ok := t.Len.(*ast.BasicLit)
+		return ok && l.Value == "0"
+	}
+	return false
+}
+
+// field parses tags of field.
+func (g *generator) field(name string, expr ast.Expr, tag reflect.StructTag) (*field, error) {
+	for _, key := range []string{"bits", "if", "len", "count", "switch", "enc", "pad", "align", "offset", "at", "checksum", "const", "magic", "reserved", "fill"} {
+		if tag.Get(key) != "" {
+			return nil, fmt.Errorf("%s tag is not supported", key)
+		}
+	}
+
+	t, err := g.resolve(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &field{name: name, t: t, e: "le"}
+
+	if bo := tag.Get("bo"); bo != "" {
+		f.e = bo
+	}
+	if f.e != "le" && f.e != "be" {
+		return nil, fmt.Errorf("unknown byte order %q", f.e)
+	}
+
+	if f.size, err = tagInt(tag, "size"); err != nil {
+		return nil, err
+	}
+	if f.num, err = tagInt(tag, "num"); err != nil {
+		return nil, err
+	}
+
+	if f.num != 0 && t.kind != kindSlice {
+		return nil, fmt.Errorf("num tag is allowed only for slices")
+	}
+
+	et := t
+	if t.kind == kindSlice || t.kind == kindArray {
+		et = t.elem
+	}
+
+	switch et.kind {
+	case kindInt, kindUint, kindByte:
+		if f.size > et.size {
+			return nil, fmt.Errorf("size %d does not fit %s", f.size, et.name)
+		}
+		if f.size == 0 {
+			f.size = et.size
+		}
+	case kindString:
+	default:
+		if f.size != 0 {
+			return nil, fmt.Errorf("size tag is not allowed for %s", et.name)
+		}
+		f.size = et.size
+	}
+
+	if et.kind == kindSlice || et.kind == kindArray {
+		return nil, fmt.Errorf("unsupported type %s", t.name)
+	}
+
+	if prefix := tag.Get("prefix"); prefix != "" {
+		if t.kind != kindString && t.kind != kindSlice {
+			return nil, fmt.Errorf("prefix tag is allowed only for strings and slices")
+		}
+
+		opts := strings.Split(prefix, ",")
+		switch opts[0] {
+		case "u8":
+			f.prefix = 1
+		case "u16":
+			f.prefix = 2
+		case "u32":
+			f.prefix = 4
+		case "u64":
+			f.prefix = 8
+		default:
+			return nil, fmt.Errorf("unknown prefix type %q", opts[0])
+		}
+
+		f.pe = f.e
+		if len(opts) > 1 {
+			f.pe = opts[1]
+		}
+		if f.pe != "le" && f.pe != "be" || len(opts) > 2 {
+			return nil, fmt.Errorf("invalid prefix %q", prefix)
+		}
+
+		f.num = 0
+	}
+
+	if tag.Get("bo") != "" && f.nested() {
+		return nil, fmt.Errorf("bo tag of struct field is not supported")
+	}
+
+	return f, nil
+}
+
+// nested reports whether field is struct, pointer to struct or slice of them.
+func (f *field) nested() bool {
+	t := f.t
+	if t.kind == kindSlice || t.kind == kindArray {
+		t = t.elem
+	}
+
+	return t.kind == kindStruct || t.kind == kindPtr
+}
+
+// unbounded reports whether field takes all remaining bytes on decoding.
+func (f *field) unbounded() bool {
+	return f.t.kind == kindSlice && f.num == 0 && f.prefix == 0
+}
+
+func tagInt(tag reflect.StructTag, name string) (int, error) {
+	s := tag.Get(name)
+	if s == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s tag %q", name, s)
+	}
+
+	return n, nil
+}
+
+// resolve returns type of expression.
+func (g *generator) resolve(expr ast.Expr) (*typ, error) {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		if t, ok := basicTypes[x.Name]; ok {
+			t.name = x.Name
+			return &t, nil
+		}
+
+		if g.ownEncoding(x.Name) {
+			return nil, fmt.Errorf("type %s has own encoding methods or registered codec", x.Name)
+		}
+
+		ts, ok := g.specs[x.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %s", x.Name)
+		}
+
+		if _, ok := ts.Type.(*ast.StructType); ok {
+			g.queueType(x.Name)
+			return &typ{kind: kindStruct, name: x.Name}, nil
+		}
+
+		t, err := g.resolve(ts.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		named := *t
+		named.name = x.Name
+		return &named, nil
+
+	case *ast.StarExpr:
+		elem, err := g.resolve(x.X)
+		if err != nil {
+			return nil, err
+		}
+		if elem.kind != kindStruct {
+			return nil, fmt.Errorf("unsupported pointer type *%s", elem.name)
+		}
+
+		return &typ{kind: kindPtr, name: "*" + elem.name, elem: elem}, nil
+
+	case *ast.ArrayType:
+		elem, err := g.resolve(x.Elt)
+		if err != nil {
+			return nil, err
+		}
+
+		if x.Len == nil {
+			return &typ{kind: kindSlice, name: "[]" + elem.name, elem: elem}, nil
+		}
+
+		lit, ok := x.Len.(*ast.BasicLit)
+		if !ok {
+			return nil, fmt.Errorf("length of array should be integer literal")
+		}
+		n, err := strconv.ParseInt(lit.Value, 0, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		return &typ{kind: kindArray, name: fmt.Sprintf("[%d]%s", n, elem.name), len: int(n), elem: elem}, nil
+
+	case *ast.SelectorExpr:
+		pkg, ok := x.X.(*ast.Ident)
+		if !ok {
+			break
+		}
+
+		name := pkg.Name + "." + x.Sel.Name
+		if g.registered[name] {
+			return nil, fmt.Errorf("type %s has registered codec", name)
+		}
+
+		underlying, ok := externalTypes[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported type %s", name)
+		}
+
+		t, err := g.resolve(&ast.ArrayType{Elt: ast.NewIdent(underlying[2:])})
+		if err != nil {
+			return nil, err
+		}
+
+		g.used[g.imports[pkg.Name]] = true
+		t.name = name
+		return t, nil
+	}
+
+	return nil, fmt.Errorf("unsupported type %T", expr)
+}
+
+//
+// encode
+
+// encodeField generates encoding of field.
+func (g *generator) encodeField(f *field) {
+	v := "x." + f.name
+	path := strconv.Quote(f.name)
+
+	if f.prefix != 0 {
+		g.putPrefix(f, "len("+v+")", path)
+	}
+
+	switch f.t.kind {
+	case kindString:
+		if f.prefix != 0 {
+			g.printf("b = append(b, %s...)\n", v)
+			return
+		}
+		g.encodeValue(v, f.t, f, path)
+
+	case kindSlice, kindArray:
+		et := f.t.elem
+		ipath := fmt.Sprintf("%q + strconv.Itoa(i) + \"]\"", f.name+"[")
+
+		switch {
+		case et.kind == kindByte && f.t.kind == kindArray:
+			g.printf("b = append(b, %s[:]...)\n", v)
+
+		case et.kind == kindByte && f.num != 0:
+			g.printf("b = append(b, make([]byte, %d)...)\n", f.num)
+			g.printf("copy(b[len(b)-%d:], %s)\n", f.num, v)
+
+		case et.kind == kindByte:
+			g.printf("b = append(b, %s...)\n", v)
+
+		case f.num != 0:
+			g.uses(et)
+			g.printf("for i := 0; i < %d; i++ {\n", f.num)
+			g.printf("var e %s\n", et.name)
+			g.printf("if i < len(%s) {\ne = %s[i]\n}\n", v, v)
+			g.encodeValue("e", et, f, ipath)
+			g.printf("}\n")
+
+		default:
+			g.uses(et)
+			g.printf("for i := range %s {\n", v)
+			g.encodeValue(v+"[i]", et, f, ipath)
+			g.printf("}\n")
+		}
+
+	default:
+		g.encodeValue(v, f.t, f, path)
+	}
+}
+
+// uses marks packages used by element paths of slices of type et.
+func (g *generator) uses(et *typ) {
+	if et.kind == kindStruct || et.kind == kindPtr {
+		g.used["strconv"] = true
+	}
+}
+
+// encodeValue generates encoding of value v of type t.
+func (g *generator) encodeValue(v string, t *typ, f *field, path string) {
+	switch t.kind {
+	case kindInt, kindUint:
+		g.printf("b = append(b, %s)\n", putBytes(v, f.size, f.e))
+
+	case kindByte:
+		g.printf("b = append(b, byte(%s))\n", v)
+
+	case kindBool:
+		g.printf("if %s {\nb = append(b, 1)\n} else {\nb = append(b, 0)\n}\n", v)
+
+	case kindFloat32:
+		g.used["math"] = true
+		g.printf("{\nu := math.Float32bits(float32(%s))\nb = append(b, %s)\n}\n", v, putBytes("u", 4, f.e))
+
+	case kindFloat64:
+		g.used["math"] = true
+		g.printf("{\nu := math.Float64bits(float64(%s))\nb = append(b, %s)\n}\n", v, putBytes("u", 8, f.e))
+
+	case kindString:
+		if f.size == 0 {
+			g.printf("b = append(b, %s...)\nb = append(b, 0)\n", v)
+			return
+		}
+		g.printf("b = append(b, make([]byte, %d)...)\n", f.size)
+		g.printf("copy(b[len(b)-%d:], %s)\n", f.size, v)
+
+	case kindStruct:
+		g.printf("if b, err = %s.MarshalStob(b); err != nil {\n", v)
+		g.printf("return b, stob.WrapFieldError(err, %s, 0)\n}\n", path)
+
+	case kindPtr:
+		g.printf("if %s == nil {\nb, err = new(%s).MarshalStob(b)\n} else {\nb, err = %s.MarshalStob(b)\n}\n", v, t.elem.name, v)
+		g.printf("if err != nil {\nreturn b, stob.WrapFieldError(err, %s, 0)\n}\n", path)
+	}
+}
+
+// putPrefix generates encoding of length prefix.
+func (g *generator) putPrefix(f *field, n, path string) {
+	if f.prefix < 8 {
+		g.used["fmt"] = true
+		g.printf("if uint64(%s) >= 1<<%d {\n", n, f.prefix*8)
+		g.printf("return b, stob.WrapFieldError(fmt.Errorf(\"length %%d overflows %d bytes prefix\", %s), %s, len(b))\n}\n", f.prefix, n, path)
+	}
+
+	g.printf("b = append(b, %s)\n", putBytes("uint64("+n+")", f.prefix, f.pe))
+}
+
+// putBytes returns bytes of integer v of size in byte order e.
+func putBytes(v string, size int, e string) string {
+	var bs []string
+	for i := 0; i < size; i++ {
+		shift := i * 8
+		if e == "be" {
+			shift = (size - i - 1) * 8
+		}
+
+		if shift == 0 {
+			bs = append(bs, fmt.Sprintf("byte(%s)", v))
+		} else {
+			bs = append(bs, fmt.Sprintf("byte(%s>>%d)", v, shift))
+		}
+	}
+
+	return strings.Join(bs, ", ")
+}
+
+//
+// decode
+
+// decodeField generates decoding of field.
+func (g *generator) decodeField(f *field) {
+	v := "x." + f.name
+	path := strconv.Quote(f.name)
+
+	if f.t.kind != kindString && f.t.kind != kindSlice && f.t.kind != kindArray {
+		g.decodeValue(v, f.t, f, path, true)
+		return
+	}
+
+	g.printf("{\n")
+	defer g.printf("}\n")
+
+	if f.prefix != 0 {
+		g.check(f.prefix, path)
+		g.printf("c := %s\nn += %d\n", getBytes(f.prefix, f.pe), f.prefix)
+	}
+
+	if f.t.kind == kindString {
+		if f.prefix == 0 {
+			g.decodeValue(v, f.t, f, path, true)
+			return
+		}
+		g.printf("if c > uint64(len(p)-n) {\nreturn n, stob.ShortError(%s, n, int(c), len(p)-n)\n}\n", path)
+		g.printf("%s = %s(p[n : n+int(c)])\nn += int(c)\n", v, f.t.name)
+		return
+	}
+
+	et := f.t.elem
+	ipath := fmt.Sprintf("%q + strconv.Itoa(i) + \"]\"", f.name+"[")
+
+	switch et.kind {
+	case kindString:
+		switch {
+		case f.t.kind == kindArray:
+			g.printf("for i := range %s {\n", v)
+			g.decodeValue(v+"[i]", et, f, path, true)
+			g.printf("}\n")
+		case f.prefix != 0:
+			// every string takes at least one byte, so broken count fails before decoding
+			size := f.size
+			if size == 0 {
+				size = 1
+			}
+			g.printf("if c > uint64(len(p)-n)/%d {\nreturn n, stob.ShortError(%s, n, int(c)*%d, len(p)-n)\n}\n", size, path, size)
+			g.printf("%s = make(%s, 0)\n", v, f.t.name)
+			g.printf("for i := uint64(0); i < c; i++ {\nvar e %s\n", et.name)
+			g.decodeValue("e", et, f, path, true)
+			g.printf("%s = append(%s, e)\n}\n", v, v)
+		case f.num != 0:
+			g.printf("%s = make(%s, %d)\n", v, f.t.name, f.num)
+			g.printf("for i := range %s {\n", v)
+			g.decodeValue(v+"[i]", et, f, path, true)
+			g.printf("}\n")
+		default:
+			g.printf("%s = nil\n", v)
+			g.printf("for n < len(p) {\nvar e %s\n", et.name)
+			g.decodeValue("e", et, f, path, true)
+			g.printf("%s = append(%s, e)\n}\n", v, v)
+		}
+
+	case kindStruct, kindPtr:
+		g.uses(et)
+		switch {
+		case f.t.kind == kindArray:
+			g.printf("for i := range %s {\n", v)
+			g.decodeValue(v+"[i]", et, f, ipath, true)
+			g.printf("}\n")
+		default:
+			cond := "n < len(p)"
+			if f.prefix != 0 {
+				cond = "uint64(i) < c"
+			} else if f.num != 0 {
+				cond = fmt.Sprintf("i < %d", f.num)
+			}
+
+			g.printf("%s = make(%s, 0)\n", v, f.t.name)
+			g.printf("for i := 0; %s; i++ {\n", cond)
+			if f.prefix == 0 && f.num == 0 {
+				g.printf("m := n\n")
+			}
+			if et.kind == kindPtr {
+				g.printf("e := new(%s)\n", et.elem.name)
+				g.decodeValue("e", et.elem, f, ipath, true)
+			} else {
+				g.printf("var e %s\n", et.name)
+				g.decodeValue("e", et, f, ipath, true)
+			}
+			if f.prefix == 0 && f.num == 0 {
+				// element without bytes would be decoded endlessly
+				g.used["fmt"] = true
+				g.printf("if n == m {\nreturn n, stob.WrapFieldError(fmt.Errorf(\"element takes no bytes\"), %s, n)\n}\n", ipath)
+			}
+			g.printf("%s = append(%s, e)\n}\n", v, v)
+		}
+
+	default:
+		// numbers and bools
+		switch {
+		case f.t.kind == kindArray:
+			g.printf("c := %d\n", f.t.len)
+		case f.prefix != 0:
+		case f.num != 0:
+			g.printf("c := %d\n", f.num)
+		default:
+			if f.size > 1 {
+				g.used["fmt"] = true
+				g.printf("if (len(p)-n)%%%d != 0 {\n", f.size)
+				g.printf("return n, stob.WrapFieldError(fmt.Errorf(\"%%d bytes is not multiple of element size %d\", len(p)-n), %s, n)\n}\n", f.size, path)
+			}
+			g.printf("c := (len(p) - n) / %d\n", f.size)
+		}
+
+		switch {
+		case f.prefix == 0 && f.num == 0 && f.t.kind == kindSlice:
+			// all remaining bytes are taken
+		case f.prefix != 0:
+			g.printf("if c > uint64(len(p)-n)/%d {\nreturn n, stob.ShortError(%s, n, int(c)*%d, len(p)-n)\n}\n", f.size, path, f.size)
+		default:
+			g.printf("if len(p)-n < c*%d {\nreturn n, stob.ShortError(%s, n, c*%d, len(p)-n)\n}\n", f.size, path, f.size)
+		}
+
+		if f.t.kind == kindSlice {
+			g.printf("%s = make(%s, c)\n", v, f.t.name)
+		}
+
+		if et.kind == kindByte {
+			g.printf("n += copy(%s[:], p[n:])\n", v)
+			return
+		}
+
+		g.printf("for i := range %s {\n", v)
+		g.decodeValue(v+"[i]", et, f, path, false)
+		g.printf("}\n")
+	}
+}
+
+// check generates check that n bytes are available.
+func (g *generator) check(n int, path string) {
+	g.printf("if len(p)-n < %d {\nreturn n, stob.ShortError(%s, n, %d, len(p)-n)\n}\n", n, path, n)
+}
+
+// decodeValue generates decoding of value v of type t, check is false if length of data is already checked.
+func (g *generator) decodeValue(v string, t *typ, f *field, path string, check bool) {
+	switch t.kind {
+	case kindInt, kindUint, kindByte, kindBool, kindFloat32, kindFloat64:
+		if check {
+			g.check(f.size, path)
+		}
+	}
+
+	switch t.kind {
+	case kindInt:
+		if f.size < t.size {
+			g.printf("%s = %s(stob.SignExtend(int64(%s), %d))\n", v, t.name, getBytes(f.size, f.e), f.size)
+		} else {
+			g.printf("%s = %s(%s)\n", v, t.name, getBytes(f.size, f.e))
+		}
+
+	case kindUint:
+		g.printf("%s = %s(%s)\n", v, t.name, getBytes(f.size, f.e))
+
+	case kindByte:
+		g.printf("%s = %s(p[n])\n", v, t.name)
+
+	case kindBool:
+		g.printf("%s = p[n] != 0\n", v)
+
+	case kindFloat32:
+		g.used["math"] = true
+		g.printf("%s = %s(math.Float32frombits(uint32(%s)))\n", v, t.name, getBytes(4, f.e))
+
+	case kindFloat64:
+		g.used["math"] = true
+		g.printf("%s = %s(math.Float64frombits(%s))\n", v, t.name, getBytes(8, f.e))
+
+	case kindString:
+		if f.size == 0 {
+			g.used["bytes"] = true
+			if check {
+				g.check(1, path)
+			}
+			g.printf("if j := bytes.IndexByte(p[n:], 0); j >= 0 {\n")
+			g.printf("%s = %s(p[n : n+j])\nn += j + 1\n", v, t.name)
+			g.printf("} else {\n%s = %s(p[n:])\nn = len(p)\n}\n", v, t.name)
+			return
+		}
+		g.check(f.size, path)
+		g.printf("{\ns, _ := stob.Btos(p[n : n+%d])\n%s = %s(s)\n}\nn += %d\n", f.size, v, t.name, f.size)
+		return
+
+	case kindPtr:
+		g.printf("if %s == nil {\n%s = new(%s)\n}\n", v, v, t.elem.name)
+		fallthrough
+
+	case kindStruct:
+		g.printf("{\nk, err := %s.UnmarshalStob(p[n:])\n", v)
+		g.printf("if err != nil {\nreturn n + k, stob.WrapFieldError(err, %s, n)\n}\n", path)
+		g.printf("n += k\n}\n")
+		return
+	}
+
+	g.printf("n += %d\n", f.size)
+}
+
+// getBytes returns expression of uint64 taken from size bytes at offset n in byte order e.
+func getBytes(size int, e string) string {
+	var bs []string
+	for i := 0; i < size; i++ {
+		shift := i * 8
+		if e == "be" {
+			shift = (size - i - 1) * 8
+		}
+
+		b := "uint64(p[n])"
+		if i > 0 {
+			b = fmt.Sprintf("uint64(p[n+%d])", i)
+		}
+		if shift != 0 {
+			b = fmt.Sprintf("%s<<%d", b, shift)
+		}
+
+		bs = append(bs, b)
+	}
+
+	return strings.Join(bs, " | ")
+}
+
+//
+// size
+
+// sizeField generates length of encoded field.
+func (g *generator) sizeField(f *field) {
+	v := "x." + f.name
+
+	if f.prefix != 0 {
+		g.printf("n += %d\n", f.prefix)
+	}
+
+	switch f.t.kind {
+	case kindString:
+		switch {
+		case f.prefix != 0:
+			g.printf("n += len(%s)\n", v)
+		case f.size != 0:
+			g.printf("n += %d\n", f.size)
+		default:
+			g.printf("n += len(%s) + 1\n", v)
+		}
+
+	case kindSlice, kindArray:
+		et := f.t.elem
+
+		count := fmt.Sprintf("len(%s)", v)
+		if f.num != 0 {
+			count = strconv.Itoa(f.num)
+		}
+
+		switch et.kind {
+		case kindString:
+			if f.size != 0 {
+				g.printf("n += %s * %d\n", count, f.size)
+				return
+			}
+
+			if f.num != 0 {
+				g.printf("for i := 0; i < %d; i++ {\nif i < len(%s) {\nn += len(%s[i])\n}\nn++\n}\n", f.num, v, v)
+				return
+			}
+			g.printf("for i := range %s {\nn += len(%s[i]) + 1\n}\n", v, v)
+
+		case kindStruct, kindPtr:
+			if f.num != 0 {
+				g.printf("for i := 0; i < %d; i++ {\nvar e %s\n", f.num, et.name)
+				g.printf("if i < len(%s) {\ne = %s[i]\n}\n", v, v)
+				g.sizeValue("e", et)
+				g.printf("}\n")
+				return
+			}
+			g.printf("for i := range %s {\n", v)
+			g.sizeValue(v+"[i]", et)
+			g.printf("}\n")
+
+		default:
+			g.printf("n += %s * %d\n", count, f.size)
+		}
+
+	case kindStruct, kindPtr:
+		g.sizeValue(v, f.t)
+
+	default:
+		g.printf("n += %d\n", f.size)
+	}
+}
+
+// sizeValue generates length of encoded struct v.
+func (g *generator) sizeValue(v string, t *typ) {
+	if t.kind == kindPtr {
+		g.printf("if %s == nil {\nn += new(%s).StobSize()\n} else {\nn += %s.StobSize()\n}\n", v, t.elem.name, v)
+		return
+	}
+
+	g.printf("n += %s.StobSize()\n", v)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	output := filepath.Join("internal", "gentest", "frame_stob.go")
+
+	files, err := parseDir(filepath.Join("internal", "gentest"), output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := Generate(files, []string{"Frame", "Records"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, want) {
+		t.Errorf("%s is outdated, run go generate", output)
+	}
+}
+
+func TestGenerateOwnEncoding(t *testing.T) {
+	output := filepath.Join("internal", "gentest", "frame_stob.go")
+
+	files, err := parseDir(filepath.Join("internal", "gentest"), output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// generated code would ignore binary methods of ID, so the wire format would change
+	_, err = Generate(files, []string{"Tagged"})
+	if err == nil || !strings.Contains(err.Error(), "type ID has own encoding methods") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	for _, test := range []struct {
+		src string
+		err string
+	}{
+		{"type A struct{ B uint16 `bits:\"4\"` }", "bits tag is not supported"},
+		{"type A struct{ B C }; type C uint16; func (C) Size() int { return 2 }; func (*C) Write(p []byte) (int, error) { return 0, nil }", "type C has own encoding methods"},
+		{"type A struct{ B C }; type C uint16; func init() { stob.RegisterCodec(reflect.TypeOf(C(0)), nil) }", "type C has own encoding methods or registered codec"},
+		{"type A struct{ B *C }; type C struct{}; func init() { stob.RegisterCodec(reflect.TypeOf((*C)(nil)).Elem(), nil) }", "type C has own encoding methods or registered codec"},
+		{"type A struct{ B net.IP }; func init() { stob.RegisterCodec(reflect.TypeOf(net.IP{}), nil) }", "type net.IP has registered codec"},
+		{"type A struct{ B uint16 `align:\"4\"` }", "align tag is not supported"},
+		{"type A struct{ _ struct{} `pack:\"4\"`; B uint16 }", "pack tag is not supported"},
+		{"type A struct{ B uint16; _ [2]byte }", "blank field is not supported"},
+		{"type A struct{ _ struct{} `stob:\"unexported\"`; B uint16 }", "unexported fields are not supported"},
+		{"type A struct{ B `bo:\"be\"` }; type B struct{ C uint16 }", "bo tag of struct field is not supported"},
+		{"type A struct{ B []byte; C byte }", "A.B takes all remaining bytes"},
+		{"type A struct{ B int16 `size:\"4\"` }", "size 4 does not fit int16"},
+		{"type A struct{ B map[int]int }", "unsupported type"},
+		{"type A struct{ B C }", "unknown type C"},
+		{"type A int", "type A is not a struct"},
+		{"type B struct{}", "type A is not found"},
+	} {
+		f, err := parser.ParseFile(token.NewFileSet(), "a.go", "package a\n"+test.src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = Generate([]*ast.File{f}, []string{"A"})
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error %q, got %v", test.src, test.err, err)
+		}
+	}
+}
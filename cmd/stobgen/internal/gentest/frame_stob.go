@@ -0,0 +1,730 @@
+// Code generated by stobgen; DO NOT EDIT.
+
+package gentest
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+
+	"github.com/sg3des/stob"
+)
+
+// MarshalStob appends encoded Frame to b.
+func (x *Frame) MarshalStob(b []byte) ([]byte, error) {
+	var err error
+
+	// Dst
+	b = append(b, make([]byte, 6)...)
+	copy(b[len(b)-6:], x.Dst)
+
+	// Kind
+	b = append(b, byte(x.Kind>>8), byte(x.Kind))
+
+	// Header
+	if b, err = x.Header.MarshalStob(b); err != nil {
+		return b, stob.WrapFieldError(err, "Header", 0)
+	}
+
+	// Ptr
+	if x.Ptr == nil {
+		b, err = new(Header).MarshalStob(b)
+	} else {
+		b, err = x.Ptr.MarshalStob(b)
+	}
+	if err != nil {
+		return b, stob.WrapFieldError(err, "Ptr", 0)
+	}
+
+	// Name
+	b = append(b, make([]byte, 8)...)
+	copy(b[len(b)-8:], x.Name)
+
+	// Str
+	b = append(b, x.Str...)
+	b = append(b, 0)
+
+	// Text
+	if uint64(len(x.Text)) >= 1<<8 {
+		return b, stob.WrapFieldError(fmt.Errorf("length %d overflows 1 bytes prefix", len(x.Text)), "Text", len(b))
+	}
+	b = append(b, byte(uint64(len(x.Text))))
+	b = append(b, x.Text...)
+
+	// Narrow
+	b = append(b, byte(x.Narrow>>16), byte(x.Narrow>>8), byte(x.Narrow))
+
+	// Int
+	b = append(b, byte(x.Int), byte(x.Int>>8), byte(x.Int>>16), byte(x.Int>>24), byte(x.Int>>32), byte(x.Int>>40), byte(x.Int>>48), byte(x.Int>>56))
+
+	// Uint
+	b = append(b, byte(x.Uint), byte(x.Uint>>8), byte(x.Uint>>16), byte(x.Uint>>24), byte(x.Uint>>32))
+
+	// Byte
+	b = append(b, byte(x.Byte))
+
+	// Bool
+	if x.Bool {
+		b = append(b, 1)
+	} else {
+		b = append(b, 0)
+	}
+
+	// F32
+	{
+		u := math.Float32bits(float32(x.F32))
+		b = append(b, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+	}
+
+	// F64
+	{
+		u := math.Float64bits(float64(x.F64))
+		b = append(b, byte(u), byte(u>>8), byte(u>>16), byte(u>>24), byte(u>>32), byte(u>>40), byte(u>>48), byte(u>>56))
+	}
+
+	// Bytes4
+	b = append(b, x.Bytes4[:]...)
+
+	// Ints
+	for i := range x.Ints {
+		b = append(b, byte(x.Ints[i]>>8), byte(x.Ints[i]))
+	}
+
+	// Uints
+	for i := 0; i < 3; i++ {
+		var e uint32
+		if i < len(x.Uints) {
+			e = x.Uints[i]
+		}
+		b = append(b, byte(e), byte(e>>8))
+	}
+
+	// Bools
+	for i := 0; i < 2; i++ {
+		var e bool
+		if i < len(x.Bools) {
+			e = x.Bools[i]
+		}
+		if e {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+	}
+
+	// Floats
+	if uint64(len(x.Floats)) >= 1<<16 {
+		return b, stob.WrapFieldError(fmt.Errorf("length %d overflows 2 bytes prefix", len(x.Floats)), "Floats", len(b))
+	}
+	b = append(b, byte(uint64(len(x.Floats))>>8), byte(uint64(len(x.Floats))))
+	for i := range x.Floats {
+		{
+			u := math.Float64bits(float64(x.Floats[i]))
+			b = append(b, byte(u), byte(u>>8), byte(u>>16), byte(u>>24), byte(u>>32), byte(u>>40), byte(u>>48), byte(u>>56))
+		}
+	}
+
+	// Strs
+	for i := 0; i < 2; i++ {
+		var e string
+		if i < len(x.Strs) {
+			e = x.Strs[i]
+		}
+		b = append(b, make([]byte, 4)...)
+		copy(b[len(b)-4:], e)
+	}
+
+	// Names
+	for i := range x.Names {
+		b = append(b, x.Names[i]...)
+		b = append(b, 0)
+	}
+
+	// PStrs
+	if uint64(len(x.PStrs)) >= 1<<8 {
+		return b, stob.WrapFieldError(fmt.Errorf("length %d overflows 1 bytes prefix", len(x.PStrs)), "PStrs", len(b))
+	}
+	b = append(b, byte(uint64(len(x.PStrs))))
+	for i := range x.PStrs {
+		b = append(b, x.PStrs[i]...)
+		b = append(b, 0)
+	}
+
+	// Blob
+	if uint64(len(x.Blob)) >= 1<<32 {
+		return b, stob.WrapFieldError(fmt.Errorf("length %d overflows 4 bytes prefix", len(x.Blob)), "Blob", len(b))
+	}
+	b = append(b, byte(uint64(len(x.Blob))), byte(uint64(len(x.Blob))>>8), byte(uint64(len(x.Blob))>>16), byte(uint64(len(x.Blob))>>24))
+	b = append(b, x.Blob...)
+
+	// Headers
+	for i := range x.Headers {
+		if b, err = x.Headers[i].MarshalStob(b); err != nil {
+			return b, stob.WrapFieldError(err, "Headers["+strconv.Itoa(i)+"]", 0)
+		}
+	}
+
+	// Ptrs
+	for i := 0; i < 2; i++ {
+		var e *Header
+		if i < len(x.Ptrs) {
+			e = x.Ptrs[i]
+		}
+		if e == nil {
+			b, err = new(Header).MarshalStob(b)
+		} else {
+			b, err = e.MarshalStob(b)
+		}
+		if err != nil {
+			return b, stob.WrapFieldError(err, "Ptrs["+strconv.Itoa(i)+"]", 0)
+		}
+	}
+
+	// Items
+	if uint64(len(x.Items)) >= 1<<8 {
+		return b, stob.WrapFieldError(fmt.Errorf("length %d overflows 1 bytes prefix", len(x.Items)), "Items", len(b))
+	}
+	b = append(b, byte(uint64(len(x.Items))))
+	for i := range x.Items {
+		if b, err = x.Items[i].MarshalStob(b); err != nil {
+			return b, stob.WrapFieldError(err, "Items["+strconv.Itoa(i)+"]", 0)
+		}
+	}
+
+	// Rest
+	for i := range x.Rest {
+		b = append(b, byte(x.Rest[i]), byte(x.Rest[i]>>8))
+	}
+
+	return b, nil
+}
+
+// UnmarshalStob decodes Frame from p, returns count of decoded bytes.
+func (x *Frame) UnmarshalStob(p []byte) (int, error) {
+	var n int
+
+	// Dst
+	{
+		c := 6
+		if len(p)-n < c*1 {
+			return n, stob.ShortError("Dst", n, c*1, len(p)-n)
+		}
+		x.Dst = make(net.HardwareAddr, c)
+		n += copy(x.Dst[:], p[n:])
+	}
+
+	// Kind
+	if len(p)-n < 2 {
+		return n, stob.ShortError("Kind", n, 2, len(p)-n)
+	}
+	x.Kind = Kind(uint64(p[n])<<8 | uint64(p[n+1]))
+	n += 2
+
+	// Header
+	{
+		k, err := x.Header.UnmarshalStob(p[n:])
+		if err != nil {
+			return n + k, stob.WrapFieldError(err, "Header", n)
+		}
+		n += k
+	}
+
+	// Ptr
+	if x.Ptr == nil {
+		x.Ptr = new(Header)
+	}
+	{
+		k, err := x.Ptr.UnmarshalStob(p[n:])
+		if err != nil {
+			return n + k, stob.WrapFieldError(err, "Ptr", n)
+		}
+		n += k
+	}
+
+	// Name
+	{
+		if len(p)-n < 8 {
+			return n, stob.ShortError("Name", n, 8, len(p)-n)
+		}
+		{
+			s, _ := stob.Btos(p[n : n+8])
+			x.Name = Name(s)
+		}
+		n += 8
+	}
+
+	// Str
+	{
+		if len(p)-n < 1 {
+			return n, stob.ShortError("Str", n, 1, len(p)-n)
+		}
+		if j := bytes.IndexByte(p[n:], 0); j >= 0 {
+			x.Str = string(p[n : n+j])
+			n += j + 1
+		} else {
+			x.Str = string(p[n:])
+			n = len(p)
+		}
+	}
+
+	// Text
+	{
+		if len(p)-n < 1 {
+			return n, stob.ShortError("Text", n, 1, len(p)-n)
+		}
+		c := uint64(p[n])
+		n += 1
+		if c > uint64(len(p)-n) {
+			return n, stob.ShortError("Text", n, int(c), len(p)-n)
+		}
+		x.Text = string(p[n : n+int(c)])
+		n += int(c)
+	}
+
+	// Narrow
+	if len(p)-n < 3 {
+		return n, stob.ShortError("Narrow", n, 3, len(p)-n)
+	}
+	x.Narrow = int32(stob.SignExtend(int64(uint64(p[n])<<16|uint64(p[n+1])<<8|uint64(p[n+2])), 3))
+	n += 3
+
+	// Int
+	if len(p)-n < 8 {
+		return n, stob.ShortError("Int", n, 8, len(p)-n)
+	}
+	x.Int = int(uint64(p[n]) | uint64(p[n+1])<<8 | uint64(p[n+2])<<16 | uint64(p[n+3])<<24 | uint64(p[n+4])<<32 | uint64(p[n+5])<<40 | uint64(p[n+6])<<48 | uint64(p[n+7])<<56)
+	n += 8
+
+	// Uint
+	if len(p)-n < 5 {
+		return n, stob.ShortError("Uint", n, 5, len(p)-n)
+	}
+	x.Uint = uint(uint64(p[n]) | uint64(p[n+1])<<8 | uint64(p[n+2])<<16 | uint64(p[n+3])<<24 | uint64(p[n+4])<<32)
+	n += 5
+
+	// Byte
+	if len(p)-n < 1 {
+		return n, stob.ShortError("Byte", n, 1, len(p)-n)
+	}
+	x.Byte = byte(p[n])
+	n += 1
+
+	// Bool
+	if len(p)-n < 1 {
+		return n, stob.ShortError("Bool", n, 1, len(p)-n)
+	}
+	x.Bool = p[n] != 0
+	n += 1
+
+	// F32
+	if len(p)-n < 4 {
+		return n, stob.ShortError("F32", n, 4, len(p)-n)
+	}
+	x.F32 = float32(math.Float32frombits(uint32(uint64(p[n])<<24 | uint64(p[n+1])<<16 | uint64(p[n+2])<<8 | uint64(p[n+3]))))
+	n += 4
+
+	// F64
+	if len(p)-n < 8 {
+		return n, stob.ShortError("F64", n, 8, len(p)-n)
+	}
+	x.F64 = float64(math.Float64frombits(uint64(p[n]) | uint64(p[n+1])<<8 | uint64(p[n+2])<<16 | uint64(p[n+3])<<24 | uint64(p[n+4])<<32 | uint64(p[n+5])<<40 | uint64(p[n+6])<<48 | uint64(p[n+7])<<56))
+	n += 8
+
+	// Bytes4
+	{
+		c := 4
+		if len(p)-n < c*1 {
+			return n, stob.ShortError("Bytes4", n, c*1, len(p)-n)
+		}
+		n += copy(x.Bytes4[:], p[n:])
+	}
+
+	// Ints
+	{
+		c := 2
+		if len(p)-n < c*2 {
+			return n, stob.ShortError("Ints", n, c*2, len(p)-n)
+		}
+		for i := range x.Ints {
+			x.Ints[i] = int16(uint64(p[n])<<8 | uint64(p[n+1]))
+			n += 2
+		}
+	}
+
+	// Uints
+	{
+		c := 3
+		if len(p)-n < c*2 {
+			return n, stob.ShortError("Uints", n, c*2, len(p)-n)
+		}
+		x.Uints = make([]uint32, c)
+		for i := range x.Uints {
+			x.Uints[i] = uint32(uint64(p[n]) | uint64(p[n+1])<<8)
+			n += 2
+		}
+	}
+
+	// Bools
+	{
+		c := 2
+		if len(p)-n < c*1 {
+			return n, stob.ShortError("Bools", n, c*1, len(p)-n)
+		}
+		x.Bools = make([]bool, c)
+		for i := range x.Bools {
+			x.Bools[i] = p[n] != 0
+			n += 1
+		}
+	}
+
+	// Floats
+	{
+		if len(p)-n < 2 {
+			return n, stob.ShortError("Floats", n, 2, len(p)-n)
+		}
+		c := uint64(p[n])<<8 | uint64(p[n+1])
+		n += 2
+		if c > uint64(len(p)-n)/8 {
+			return n, stob.ShortError("Floats", n, int(c)*8, len(p)-n)
+		}
+		x.Floats = make([]float64, c)
+		for i := range x.Floats {
+			x.Floats[i] = float64(math.Float64frombits(uint64(p[n]) | uint64(p[n+1])<<8 | uint64(p[n+2])<<16 | uint64(p[n+3])<<24 | uint64(p[n+4])<<32 | uint64(p[n+5])<<40 | uint64(p[n+6])<<48 | uint64(p[n+7])<<56))
+			n += 8
+		}
+	}
+
+	// Strs
+	{
+		x.Strs = make([]string, 2)
+		for i := range x.Strs {
+			if len(p)-n < 4 {
+				return n, stob.ShortError("Strs", n, 4, len(p)-n)
+			}
+			{
+				s, _ := stob.Btos(p[n : n+4])
+				x.Strs[i] = string(s)
+			}
+			n += 4
+		}
+	}
+
+	// Names
+	{
+		for i := range x.Names {
+			if len(p)-n < 1 {
+				return n, stob.ShortError("Names", n, 1, len(p)-n)
+			}
+			if j := bytes.IndexByte(p[n:], 0); j >= 0 {
+				x.Names[i] = string(p[n : n+j])
+				n += j + 1
+			} else {
+				x.Names[i] = string(p[n:])
+				n = len(p)
+			}
+		}
+	}
+
+	// PStrs
+	{
+		if len(p)-n < 1 {
+			return n, stob.ShortError("PStrs", n, 1, len(p)-n)
+		}
+		c := uint64(p[n])
+		n += 1
+		if c > uint64(len(p)-n)/1 {
+			return n, stob.ShortError("PStrs", n, int(c)*1, len(p)-n)
+		}
+		x.PStrs = make([]string, 0)
+		for i := uint64(0); i < c; i++ {
+			var e string
+			if len(p)-n < 1 {
+				return n, stob.ShortError("PStrs", n, 1, len(p)-n)
+			}
+			if j := bytes.IndexByte(p[n:], 0); j >= 0 {
+				e = string(p[n : n+j])
+				n += j + 1
+			} else {
+				e = string(p[n:])
+				n = len(p)
+			}
+			x.PStrs = append(x.PStrs, e)
+		}
+	}
+
+	// Blob
+	{
+		if len(p)-n < 4 {
+			return n, stob.ShortError("Blob", n, 4, len(p)-n)
+		}
+		c := uint64(p[n]) | uint64(p[n+1])<<8 | uint64(p[n+2])<<16 | uint64(p[n+3])<<24
+		n += 4
+		if c > uint64(len(p)-n)/1 {
+			return n, stob.ShortError("Blob", n, int(c)*1, len(p)-n)
+		}
+		x.Blob = make([]byte, c)
+		n += copy(x.Blob[:], p[n:])
+	}
+
+	// Headers
+	{
+		for i := range x.Headers {
+			{
+				k, err := x.Headers[i].UnmarshalStob(p[n:])
+				if err != nil {
+					return n + k, stob.WrapFieldError(err, "Headers["+strconv.Itoa(i)+"]", n)
+				}
+				n += k
+			}
+		}
+	}
+
+	// Ptrs
+	{
+		x.Ptrs = make([]*Header, 0)
+		for i := 0; i < 2; i++ {
+			e := new(Header)
+			{
+				k, err := e.UnmarshalStob(p[n:])
+				if err != nil {
+					return n + k, stob.WrapFieldError(err, "Ptrs["+strconv.Itoa(i)+"]", n)
+				}
+				n += k
+			}
+			x.Ptrs = append(x.Ptrs, e)
+		}
+	}
+
+	// Items
+	{
+		if len(p)-n < 1 {
+			return n, stob.ShortError("Items", n, 1, len(p)-n)
+		}
+		c := uint64(p[n])
+		n += 1
+		x.Items = make([]Header, 0)
+		for i := 0; uint64(i) < c; i++ {
+			var e Header
+			{
+				k, err := e.UnmarshalStob(p[n:])
+				if err != nil {
+					return n + k, stob.WrapFieldError(err, "Items["+strconv.Itoa(i)+"]", n)
+				}
+				n += k
+			}
+			x.Items = append(x.Items, e)
+		}
+	}
+
+	// Rest
+	{
+		if (len(p)-n)%2 != 0 {
+			return n, stob.WrapFieldError(fmt.Errorf("%d bytes is not multiple of element size 2", len(p)-n), "Rest", n)
+		}
+		c := (len(p) - n) / 2
+		x.Rest = make([]int16, c)
+		for i := range x.Rest {
+			x.Rest[i] = int16(uint64(p[n]) | uint64(p[n+1])<<8)
+			n += 2
+		}
+	}
+
+	return n, nil
+}
+
+// StobSize returns length of encoded Frame in bytes.
+func (x *Frame) StobSize() int {
+	var n int
+	n += 6 * 1
+	n += 2
+	n += x.Header.StobSize()
+	if x.Ptr == nil {
+		n += new(Header).StobSize()
+	} else {
+		n += x.Ptr.StobSize()
+	}
+	n += 8
+	n += len(x.Str) + 1
+	n += 1
+	n += len(x.Text)
+	n += 3
+	n += 8
+	n += 5
+	n += 1
+	n += 1
+	n += 4
+	n += 8
+	n += len(x.Bytes4) * 1
+	n += len(x.Ints) * 2
+	n += 3 * 2
+	n += 2 * 1
+	n += 2
+	n += len(x.Floats) * 8
+	n += 2 * 4
+	for i := range x.Names {
+		n += len(x.Names[i]) + 1
+	}
+	n += 1
+	for i := range x.PStrs {
+		n += len(x.PStrs[i]) + 1
+	}
+	n += 4
+	n += len(x.Blob) * 1
+	for i := range x.Headers {
+		n += x.Headers[i].StobSize()
+	}
+	for i := 0; i < 2; i++ {
+		var e *Header
+		if i < len(x.Ptrs) {
+			e = x.Ptrs[i]
+		}
+		if e == nil {
+			n += new(Header).StobSize()
+		} else {
+			n += e.StobSize()
+		}
+	}
+	n += 1
+	for i := range x.Items {
+		n += x.Items[i].StobSize()
+	}
+	n += len(x.Rest) * 2
+	return n
+}
+
+// MarshalStob appends encoded Records to b.
+func (x *Records) MarshalStob(b []byte) ([]byte, error) {
+	var err error
+
+	// Count
+	b = append(b, byte(x.Count))
+
+	// Records
+	for i := range x.Records {
+		if b, err = x.Records[i].MarshalStob(b); err != nil {
+			return b, stob.WrapFieldError(err, "Records["+strconv.Itoa(i)+"]", 0)
+		}
+	}
+
+	return b, nil
+}
+
+// UnmarshalStob decodes Records from p, returns count of decoded bytes.
+func (x *Records) UnmarshalStob(p []byte) (int, error) {
+	var n int
+
+	// Count
+	if len(p)-n < 1 {
+		return n, stob.ShortError("Count", n, 1, len(p)-n)
+	}
+	x.Count = uint8(p[n])
+	n += 1
+
+	// Records
+	{
+		x.Records = make([]Header, 0)
+		for i := 0; n < len(p); i++ {
+			m := n
+			var e Header
+			{
+				k, err := e.UnmarshalStob(p[n:])
+				if err != nil {
+					return n + k, stob.WrapFieldError(err, "Records["+strconv.Itoa(i)+"]", n)
+				}
+				n += k
+			}
+			if n == m {
+				return n, stob.WrapFieldError(fmt.Errorf("element takes no bytes"), "Records["+strconv.Itoa(i)+"]", n)
+			}
+			x.Records = append(x.Records, e)
+		}
+	}
+
+	return n, nil
+}
+
+// StobSize returns length of encoded Records in bytes.
+func (x *Records) StobSize() int {
+	var n int
+	n += 1
+	for i := range x.Records {
+		n += x.Records[i].StobSize()
+	}
+	return n
+}
+
+// MarshalStob appends encoded Header to b.
+func (x *Header) MarshalStob(b []byte) ([]byte, error) {
+
+	// Version
+	b = append(b, byte(x.Version))
+
+	// Length
+	b = append(b, byte(x.Length>>8), byte(x.Length))
+
+	// Flags
+	for i := range x.Flags {
+		if x.Flags[i] {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+	}
+
+	// ID
+	b = append(b, byte(x.ID), byte(x.ID>>8), byte(x.ID>>16), byte(x.ID>>24), byte(x.ID>>32), byte(x.ID>>40))
+
+	return b, nil
+}
+
+// UnmarshalStob decodes Header from p, returns count of decoded bytes.
+func (x *Header) UnmarshalStob(p []byte) (int, error) {
+	var n int
+
+	// Version
+	if len(p)-n < 1 {
+		return n, stob.ShortError("Version", n, 1, len(p)-n)
+	}
+	x.Version = byte(p[n])
+	n += 1
+
+	// Length
+	if len(p)-n < 2 {
+		return n, stob.ShortError("Length", n, 2, len(p)-n)
+	}
+	x.Length = uint16(uint64(p[n])<<8 | uint64(p[n+1]))
+	n += 2
+
+	// Flags
+	{
+		c := 2
+		if len(p)-n < c*1 {
+			return n, stob.ShortError("Flags", n, c*1, len(p)-n)
+		}
+		for i := range x.Flags {
+			x.Flags[i] = p[n] != 0
+			n += 1
+		}
+	}
+
+	// ID
+	if len(p)-n < 6 {
+		return n, stob.ShortError("ID", n, 6, len(p)-n)
+	}
+	x.ID = int64(stob.SignExtend(int64(uint64(p[n])|uint64(p[n+1])<<8|uint64(p[n+2])<<16|uint64(p[n+3])<<24|uint64(p[n+4])<<32|uint64(p[n+5])<<40), 6))
+	n += 6
+
+	return n, nil
+}
+
+// StobSize returns length of encoded Header in bytes.
+func (x *Header) StobSize() int {
+	var n int
+	n += 1
+	n += 2
+	n += len(x.Flags) * 1
+	n += 6
+	return n
+}
@@ -0,0 +1,189 @@
+package gentest
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/sg3des/stob"
+)
+
+func newFrame() Frame {
+	h := Header{Version: 1, Length: 0x0203, Flags: [2]bool{true, false}, ID: -5}
+
+	return Frame{
+		Dst:     []byte{1, 2, 3, 4, 5, 6},
+		Kind:    0x0a0b,
+		Header:  h,
+		Ptr:     &Header{Version: 2, ID: 1 << 40},
+		Name:    "name",
+		Str:     "str",
+		Text:    "text",
+		Narrow:  -100,
+		Int:     -1 << 40,
+		Uint:    1<<40 - 1,
+		Byte:    0xff,
+		Bool:    true,
+		F32:     1.5,
+		F64:     -2.25,
+		Bytes4:  [4]byte{9, 8, 7, 6},
+		Ints:    [2]int16{-1, 300},
+		Uints:   []uint32{1, 0xffff, 2},
+		Bools:   []bool{false, true},
+		Floats:  []float64{0.5, 1e10},
+		Strs:    []string{"ab", "abcd"},
+		Names:   [2]string{"x", ""},
+		PStrs:   []string{"p", "q", "r"},
+		Blob:    []byte{0xde, 0xad},
+		Headers: [2]Header{h, {Version: 3}},
+		Ptrs:    []*Header{{Version: 4}, nil},
+		Items:   []Header{h, h},
+		Rest:    []int16{1, -2, 3},
+	}
+}
+
+func TestFrame(t *testing.T) {
+	c, err := stob.Compile(reflect.TypeOf(Frame{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x := newFrame()
+
+	want, err := c.Encode(&x)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := x.MarshalStob(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("generated encoding differs from codec:\n% 02x\n% 02x", data, want)
+	}
+	if size := x.StobSize(); size != len(data) {
+		t.Errorf("StobSize returns %d, expected %d", size, len(data))
+	}
+
+	var a, b Frame
+	n, err := a.UnmarshalStob(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) {
+		t.Errorf("decoded %d of %d bytes", n, len(data))
+	}
+	if _, err := c.Decode(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("generated decoding differs from codec:\n%+v\n%+v", a, b)
+	}
+
+	// nil pointers are decoded as zero structs
+	x.Ptrs[1] = &Header{}
+	if !reflect.DeepEqual(a, x) {
+		t.Errorf("unexpected struct:\n%+v\n%+v", a, x)
+	}
+}
+
+func TestFrameShort(t *testing.T) {
+	x := newFrame()
+	data, err := x.MarshalStob(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rest takes all remaining bytes, so data is cut inside of it or before
+	for i := len(data) - 7; i >= 0; i-- {
+		var a Frame
+		_, err := a.UnmarshalStob(data[:i])
+
+		var fe *stob.FieldError
+		if !errors.As(err, &fe) {
+			t.Fatalf("%d bytes: expected FieldError, got %v", i, err)
+		}
+	}
+
+	var a Frame
+	_, err = a.UnmarshalStob(data[:len(data)-1])
+	if fe, ok := err.(*stob.FieldError); !ok || fe.Path != "Rest" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestFrameLargeCount(t *testing.T) {
+	x := newFrame()
+	data, err := x.MarshalStob(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// count of PStrs is broken and data is cut after it
+	i := bytes.Index(data, []byte{3, 'p', 0, 'q', 0, 'r', 0})
+	if i < 0 {
+		t.Fatal("PStrs is not found")
+	}
+	data = append(data[:i:i], 0xff, 'p', 0)
+
+	var a Frame
+	_, err = a.UnmarshalStob(data)
+	if fe, ok := err.(*stob.FieldError); !ok || fe.Path != "PStrs" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	err = stob.Unmarshal(data, &a)
+	if fe, ok := err.(*stob.FieldError); !ok || fe.Path != "PStrs" {
+		t.Errorf("unexpected error of codec %v", err)
+	}
+}
+
+func TestRecords(t *testing.T) {
+	x := Records{Count: 2, Records: []Header{{Version: 1}, {Version: 2, Length: 3}}}
+
+	data, err := stob.Marshal(&x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1+2*11 {
+		t.Errorf("unexpected data % 02x", data)
+	}
+
+	if b, err := stob.Encode(x); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("unexpected data % 02x, %v", b, err)
+	}
+
+	var a Records
+	if err := stob.Unmarshal(data, &a); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, x) {
+		t.Errorf("unexpected struct %+v", a)
+	}
+
+	_, err = a.UnmarshalStob(data[:20])
+	if fe, ok := err.(*stob.FieldError); !ok || fe.Path != "Records[1].ID" || fe.Offset != 17 {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestTagged(t *testing.T) {
+	x := Tagged{Kind: 1, ID: ID{1, 2, 3, 4}}
+
+	// struct with binary methods of field is encoded by reflection only
+	if _, ok := interface{}(&x).(stob.Marshaler); ok {
+		t.Error("Tagged has generated methods")
+	}
+
+	data, err := stob.Marshal(&x)
+	if err != nil || !bytes.Equal(data, []byte{1, 0, 4, 3, 2, 1}) {
+		t.Fatalf("unexpected data % 02x, %v", data, err)
+	}
+
+	var a Tagged
+	if err := stob.Unmarshal(data, &a); err != nil || a != x {
+		t.Errorf("unexpected struct %+v, %v", a, err)
+	}
+}
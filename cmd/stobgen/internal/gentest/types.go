@@ -0,0 +1,77 @@
+// Package gentest holds structs with generated methods for testing of stobgen.
+package gentest
+
+import (
+	"errors"
+	"net"
+)
+
+//go:generate go run github.com/sg3des/stob/cmd/stobgen -type Frame,Records
+
+type Kind uint16
+
+type Name string
+
+type Frame struct {
+	Dst     net.HardwareAddr `num:"6"`
+	Kind    Kind             `bo:"be"`
+	Header  Header
+	Ptr     *Header
+	Name    Name `size:"8"`
+	Str     string
+	Text    string `prefix:"u8"`
+	Narrow  int32  `size:"3" bo:"be"`
+	Int     int
+	Uint    uint `size:"5"`
+	Byte    byte
+	Bool    bool
+	F32     float32 `bo:"be"`
+	F64     float64
+	Bytes4  [4]byte
+	Ints    [2]int16  `bo:"be"`
+	Uints   []uint32  `num:"3" size:"2"`
+	Bools   []bool    `num:"2"`
+	Floats  []float64 `prefix:"u16,be"`
+	Strs    []string  `num:"2" size:"4"`
+	Names   [2]string
+	PStrs   []string `prefix:"u8"`
+	Blob    []byte   `prefix:"u32"`
+	Headers [2]Header
+	Ptrs    []*Header `num:"2"`
+	Items   []Header  `prefix:"u8"`
+	Rest    []int16
+	private int
+	Skipped int `stob:"-"`
+}
+
+type Header struct {
+	Version byte
+	Length  uint16 `bo:"be"`
+	Flags   [2]bool
+	ID      int64 `size:"6"`
+}
+
+type Records struct {
+	Count   uint8
+	Records []Header
+}
+
+// ID is encoded by its binary methods in reversed order, so stobgen refuses to generate methods of structs with it.
+type ID [4]byte
+
+func (id ID) MarshalBinary() ([]byte, error) {
+	return []byte{id[3], id[2], id[1], id[0]}, nil
+}
+
+func (id *ID) UnmarshalBinary(p []byte) error {
+	if len(p) != 4 {
+		return errors.New("invalid id")
+	}
+	*id = ID{p[3], p[2], p[1], p[0]}
+	return nil
+}
+
+type Tagged struct {
+	Kind Kind
+	ID   ID
+}
@@ -0,0 +1,90 @@
+// Command stobgen generates MarshalStob, UnmarshalStob and StobSize methods of structs, so stob.Marshal and stob.Unmarshal work without reflection.
+//
+// Usage in the package of structs:
+//
+//	//go:generate stobgen -type Header,Record
+//
+// Tags bo, size, num and prefix are read as by the reflection codec, and the generated code produces the same bytes.
+// Nested structs of the package get methods too. Tags bits, if, len, count and switch are not supported, as well as fields of types with own encoding: Read and Write methods, binary marshaling methods or codecs registered by stob.RegisterCodec in the package.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("stobgen: ")
+
+	types := flag.String("type", "", "comma-separated list of struct types")
+	output := flag.String("output", "", "output file, default is <first type>_stob.go")
+	flag.Parse()
+
+	if *types == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	names := strings.Split(*types, ",")
+
+	if *output == "" {
+		*output = filepath.Join(dir, strings.ToLower(names[0])+"_stob.go")
+	}
+
+	files, err := parseDir(dir, *output)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	src, err := Generate(files, names)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.WriteFile(*output, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseDir parses go files of package in dir, except tests and output file.
+func parseDir(dir, output string) ([]*ast.File, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+
+	var files []*ast.File
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") || filepath.Clean(path) == filepath.Clean(output) {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, f)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no go files in %s", dir)
+	}
+
+	return files, nil
+}
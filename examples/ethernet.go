@@ -11,7 +11,7 @@ type EthernetFrame struct {
 	EtherHeader
 	IPv4Header
 	TCPHeader
-	Data []byte
+	Data []byte `len:"Length-40"` // IPv4 total length without IPv4 and TCP headers
 }
 
 type EtherHeader struct {
@@ -21,29 +21,33 @@ type EtherHeader struct {
 }
 
 type IPv4Header struct {
-	Version  byte
-	ToS      byte
-	Length   uint16 `bo:"be"`
-	ID       uint16 `bo:"be"`
-	Flags    [2]byte
-	TTL      byte
-	Protocol byte
-	CRC      uint16 `bo:"be"`
-	Src      [4]byte
-	Dst      [4]byte
+	Version        byte `bits:"4"`
+	IHL            byte `bits:"4"`
+	ToS            byte
+	Length         uint16 `bo:"be"`
+	ID             uint16 `bo:"be"`
+	Flags          uint16 `bits:"3" bo:"be"`
+	FragmentOffset uint16 `bits:"13"`
+	TTL            byte
+	Protocol       byte
+	CRC            uint16 `bo:"be" checksum:"inet16"` // filled by Marshal and verified by Unmarshal
+	Src            [4]byte
+	Dst            [4]byte
 }
 
 //etc
 
 type TCPHeader struct {
-	Src             uint16 `bo:"be"`
-	Dst             uint16 `bo:"be"`
-	SeqNum          uint32 `bo:"be"`
-	AckNum          uint32 `bo:"be"`
-	DataOffsetFlags [2]byte
-	WindowSize      uint16 `bo:"be"`
-	CRC             uint16 `bo:"be"`
-	UrgPoint        [2]byte
+	Src        uint16 `bo:"be"`
+	Dst        uint16 `bo:"be"`
+	SeqNum     uint32 `bo:"be"`
+	AckNum     uint32 `bo:"be"`
+	DataOffset uint16 `bits:"4" bo:"be"`
+	Reserved   uint16 `bits:"3"`
+	Flags      uint16 `bits:"9"`
+	WindowSize uint16 `bo:"be"`
+	CRC        uint16 `bo:"be"` // covers pseudo-header of IP and data, so it is not a checksum of struct
+	UrgPoint   [2]byte
 }
 
 func main() {
@@ -64,8 +68,8 @@ func main() {
 var packet = []byte{
 	0x10, 0xb4, 0x41, 0x4a, 0x16, 0xb1, 0x01, 0x02,
 	0x33, 0x04, 0x71, 0x66, 0x08, 0x00, 0x45, 0x00,
-	0x05, 0xd4, 0xd6, 0xb1, 0x40, 0x00, 0x40, 0x06,
-	0xe5, 0x68, 0x0a, 0x00, 0x00, 0x02, 0x0a, 0x00,
+	0x00, 0x52, 0xd6, 0xb1, 0x40, 0x00, 0x40, 0x06,
+	0x4f, 0xf2, 0x0a, 0x00, 0x00, 0x02, 0x0a, 0x00,
 	0x00, 0x01, 0x83, 0x0b, 0x63, 0x3e, 0xbe, 0xb5,
 	0x08, 0xcf, 0xfe, 0x06, 0x33, 0x6b, 0x50, 0x10,
 	0x05, 0xac, 0x3d, 0x70, 0x00, 0x00, 0xec, 0x35,
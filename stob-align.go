@@ -0,0 +1,145 @@
+package stob
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// tagAlign parses tag with alignment, it should be a power of two.
+func tagAlign(tag reflect.StructTag, name string) (int, error) {
+	n, err := tagInt(tag, name)
+	if err != nil {
+		return 0, err
+	}
+
+	if n&(n-1) != 0 {
+		return 0, fmt.Errorf("%s tag %d is not a power of two", name, n)
+	}
+
+	return n, nil
+}
+
+// packing returns whether fields of struct rt are aligned naturally and maximum alignment of them. It is set by options or by blank field with pack tag: _ struct{} `pack:"4"`, as #pragma pack(4) does.
+func packing(rt reflect.Type, o *Options) (natural bool, pack int, err error) {
+	if o.Pack&(o.Pack-1) != 0 || o.Pack < 0 {
+		return false, 0, fmt.Errorf("stob: pack %d of options is not a power of two", o.Pack)
+	}
+
+	natural, pack = o.Align || o.Pack > 0, o.Pack
+
+	for i := 0; i < rt.NumField(); i++ {
+		rsf := rt.Field(i)
+		if _, ok := rsf.Tag.Lookup("pack"); !ok {
+			continue
+		}
+
+		if rsf.Name != "_" && !o.Lenient {
+			return false, 0, fmt.Errorf("stob: pack tag of field %s is allowed only for blank field", rsf.Name)
+		}
+
+		n, err := tagAlign(rsf.Tag, "pack")
+		if err != nil && !o.Lenient {
+			return false, 0, fmt.Errorf("stob: struct %s: %s", rt, err)
+		}
+
+		natural, pack = true, n
+	}
+
+	return natural, pack, nil
+}
+
+// layout sets alignment of fields and struct, and calculates length of struct with padding.
+func (c *Codec) layout() error {
+	natural, pack, err := packing(c.rt, c.o)
+	if err != nil {
+		return err
+	}
+
+	var off int
+
+	// dynamic is true after nested struct with positioned fields, its length depends on its position
+	var dynamic bool
+
+	for _, f := range c.fields {
+		if f.align == 0 && natural {
+			f.align = f.naturalAlign()
+			if pack != 0 && f.align > pack {
+				f.align = pack
+			}
+		}
+
+		if f.align > c.align {
+			c.align = f.align
+		}
+
+		if f.pos != nil && f.pos.ref == nil && f.cond == nil && !dynamic {
+			if f.pos.off < off && !c.o.Lenient {
+				return fmt.Errorf("stob: field %s at offset %d overlaps previous fields, which end at %d", f.rsf.Name, f.pos.off, off)
+			}
+			if f.pos.off > off {
+				off = f.pos.off
+			}
+		}
+
+		if f.cond == nil {
+			off += f.padding(off) + f.len
+		}
+
+		if f.nested() && f.s.pos {
+			dynamic = true
+		}
+	}
+
+	c.len = off + c.trailing(off)
+	if dynamic {
+		c.len = 0
+	}
+
+	return nil
+}
+
+// trailing returns count of zero bytes after the last field, which make length of struct a multiple of its alignment.
+func (c *Codec) trailing(off int) int {
+	if c.align > 1 {
+		return (c.align - off%c.align) % c.align
+	}
+	return 0
+}
+
+// padding returns count of zero bytes before field placed at offset off of struct.
+func (f *field) padding(off int) int {
+	n := f.pad
+	if f.align > 1 {
+		n += (f.align - (off+n)%f.align) % f.align
+	}
+	return n
+}
+
+// naturalAlign returns alignment of field as C compiler places it: numbers are aligned to their size, structs to the largest alignment of their fields, variable-length values are not aligned.
+func (f *field) naturalAlign() int {
+	switch {
+	case f.group != nil:
+		return sizeAlign(f.group.size)
+	case f.opaque() || f.enc != 0 || f.prefix != nil || f.rk == reflect.String || f.rk == reflect.Interface:
+		return 1
+	case reflect.PtrTo(baseType(f.rt)).Implements(readerType):
+		return 1
+	case f.s != nil:
+		if f.s.align > 1 {
+			return f.s.align
+		}
+		return 1
+	case f.rk == reflect.Struct || f.rk == reflect.Ptr:
+		return 1
+	}
+
+	return sizeAlign(f.size)
+}
+
+// sizeAlign returns alignment of number of size n.
+func sizeAlign(n int) int {
+	if n > 0 && n <= 8 && n&(n-1) == 0 {
+		return n
+	}
+	return 1
+}
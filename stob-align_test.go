@@ -0,0 +1,175 @@
+package stob
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// AlignC is struct { char a; int32_t b; int16_t c; }
+type AlignC struct {
+	A uint8
+	B int32
+	C int16
+}
+
+// AlignNested is struct { char a; AlignC c; double d; uint8_t n[3]; }
+type AlignNested struct {
+	A uint8
+	C AlignC
+	D float64
+	N [3]uint8
+}
+
+// AlignPacked is struct { char a; double d; int16_t c; } under #pragma pack(2).
+type AlignPacked struct {
+	_ struct{} `pack:"2"`
+	A uint8
+	D float64
+	C int16
+}
+
+type AlignTags struct {
+	A uint8
+	B uint8  `pad:"3"`
+	C uint16 `align:"8"`
+	D uint8
+}
+
+func TestAlign(t *testing.T) {
+	o := &Options{Align: true}
+
+	for _, test := range []struct {
+		x    interface{}
+		o    *Options
+		len  int
+		data []byte
+	}{
+		{
+			&AlignC{A: 1, B: 2, C: 3}, o, 12,
+			[]byte{1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0},
+		},
+		{
+			&AlignC{A: 1, B: 2, C: 3}, &Options{Pack: 2}, 8,
+			[]byte{1, 0, 2, 0, 0, 0, 3, 0},
+		},
+		{
+			&AlignC{A: 1, B: 2, C: 3}, &Options{Pack: 1}, 7,
+			[]byte{1, 2, 0, 0, 0, 3, 0},
+		},
+		{
+			&AlignNested{A: 1, C: AlignC{A: 2, B: 3, C: 4}, D: 0, N: [3]uint8{5, 6, 7}}, o, 32,
+			[]byte{
+				1, 0, 0, 0,
+				2, 0, 0, 0, 3, 0, 0, 0, 4, 0, 0, 0,
+				0, 0, 0, 0, 0, 0, 0, 0,
+				5, 6, 7, 0, 0, 0, 0, 0,
+			},
+		},
+		{
+			&AlignPacked{A: 1, C: 2}, defaultOptions, 12,
+			[]byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0},
+		},
+		{
+			// struct is aligned as its most aligned field
+			&AlignTags{A: 1, B: 2, C: 3, D: 4}, defaultOptions, 16,
+			[]byte{1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 4, 0, 0, 0, 0, 0},
+		},
+		{
+			&AlignTags{A: 1, B: 2, C: 3, D: 4}, o, 16,
+			[]byte{1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 4, 0, 0, 0, 0, 0},
+		},
+	} {
+		rt := reflect.TypeOf(test.x).Elem()
+
+		c, err := test.o.Compile(rt)
+		if err != nil {
+			t.Errorf("%s: %v", rt, err)
+			continue
+		}
+		if c.len != test.len {
+			t.Errorf("%s: length of struct is %d, expected %d", rt, c.len, test.len)
+		}
+
+		data, err := test.o.Marshal(test.x)
+		if err != nil {
+			t.Errorf("%s: %v", rt, err)
+			continue
+		}
+		if !bytes.Equal(data, test.data) {
+			t.Errorf("%s: unexpected data\n% 02x\n% 02x", rt, data, test.data)
+		}
+
+		if n, err := test.o.SizeOf(test.x); err != nil || n != len(data) {
+			t.Errorf("%s: SizeOf returns %d, %v, expected %d", rt, n, err, len(data))
+		}
+
+		b := reflect.New(rt)
+		if err := test.o.Unmarshal(data, b.Interface()); err != nil {
+			t.Errorf("%s: %v", rt, err)
+			continue
+		}
+		if !reflect.DeepEqual(test.x, b.Interface()) {
+			t.Errorf("%s: decoded struct is not equal\n%+v\n%+v", rt, test.x, b.Interface())
+		}
+	}
+}
+
+func TestAlignSlice(t *testing.T) {
+	type list struct {
+		N     uint8
+		Items []AlignC `num:"2"`
+	}
+
+	o := &Options{Align: true}
+
+	a := list{N: 2, Items: []AlignC{{A: 1, B: 2, C: 3}, {A: 4, B: 5, C: 6}}}
+	data, err := o.Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 28 {
+		t.Errorf("unexpected length %d of data % 02x", len(data), data)
+	}
+
+	var b list
+	if err := o.Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", a, b)
+	}
+}
+
+func TestAlignErrors(t *testing.T) {
+	for _, test := range []struct {
+		x   interface{}
+		o   *Options
+		err string
+	}{
+		{&struct {
+			A uint16 `align:"3"`
+		}{}, defaultOptions, "align tag 3 is not a power of two"},
+		{&struct {
+			A uint16 `pad:"x"`
+		}{}, defaultOptions, `invalid pad tag "x"`},
+		{&struct {
+			A uint8 `pack:"2"`
+		}{}, defaultOptions, "allowed only for blank field"},
+		{&struct {
+			_ struct{} `pack:"6"`
+			A uint8
+		}{}, defaultOptions, "pack tag 6 is not a power of two"},
+		{&struct {
+			A uint8 `bits:"4"`
+			B uint8 `bits:"4" pad:"1"`
+		}{}, defaultOptions, "allowed only for the first field of group"},
+		{&struct{ A uint8 }{}, &Options{Pack: 3}, "pack 3 of options"},
+	} {
+		_, err := test.o.Marshal(test.x)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%T: expected error %q, got %v", test.x, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package stob
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+var (
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryAppenderType    = reflect.TypeOf((*encoding.BinaryAppender)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	writerToType          = reflect.TypeOf((*io.WriterTo)(nil)).Elem()
+	readerFromType        = reflect.TypeOf((*io.ReaderFrom)(nil)).Elem()
+)
+
+// isBinary reports whether values of type rt are encoded by standard interfaces: one of encoding.BinaryMarshaler, encoding.BinaryAppender, io.WriterTo and one of encoding.BinaryUnmarshaler, io.ReaderFrom. Type implementing only one direction is encoded by its kind. Own Reader and Writer interfaces take precedence.
+func isBinary(rt reflect.Type) bool {
+	pt := reflect.PtrTo(baseType(rt))
+	if pt.Implements(readerType) || pt.Implements(writerType) {
+		return false
+	}
+
+	return implementsAny(pt, binaryMarshalerType, binaryAppenderType, writerToType) && implementsAny(pt, binaryUnmarshalerType, readerFromType)
+}
+
+// implementsAny reports whether type rt implements one of interfaces.
+func implementsAny(rt reflect.Type, its ...reflect.Type) bool {
+	for _, it := range its {
+		if rt.Implements(it) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendWriter is io.Writer appending to slice.
+type appendWriter struct {
+	b []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
+
+//
+// binary
+
+// Binary encodes value with its standard binary marshaling method.
+func (f *field) Binary(b []byte, rv reflect.Value) ([]byte, error) {
+	return f.frame(b, rv, f.marshalBinary)
+}
+
+// frame encodes opaque value by function enc, checks its size and fills length prefix after encoding.
+func (f *field) frame(b []byte, rv reflect.Value, enc fieldReader) (_ []byte, err error) {
+	at := len(b)
+	if f.prefix != nil && f.prefix.enc == 0 {
+		b, _ = extend(b, f.prefix.size)
+	}
+
+	start := len(b)
+	if b, err = enc(b, rv); err != nil {
+		return b, err
+	}
+
+	n := len(b) - start
+	if f.size != 0 && n != f.size {
+		return b, fmt.Errorf("%s is encoded to %d bytes, size is %d", f.rt, n, f.size)
+	}
+
+	switch {
+	case f.prefix == nil:
+	case f.prefix.enc != 0:
+		// variable-length prefix is inserted before value
+		v := append([]byte(nil), b[start:]...)
+		if b, err = f.prefix.put(b[:at], n); err != nil {
+			return b, err
+		}
+		b = append(b, v...)
+	default:
+		err = f.prefix.set(b[at:start], n)
+	}
+
+	return b, err
+}
+
+// marshalBinary appends value marshaled by encoding.BinaryAppender, encoding.BinaryMarshaler or io.WriterTo.
+func (f *field) marshalBinary(b []byte, rv reflect.Value) ([]byte, error) {
+	switch v := addr(rv).Interface().(type) {
+	case encoding.BinaryAppender:
+		return v.AppendBinary(b)
+
+	case encoding.BinaryMarshaler:
+		p, err := v.MarshalBinary()
+		return append(b, p...), err
+
+	case io.WriterTo:
+		w := &appendWriter{b: b}
+		_, err := v.WriteTo(w)
+		return w.b, err
+	}
+
+	return b, fmt.Errorf("%s does not implement encoding.BinaryMarshaler or io.WriterTo", f.rt)
+}
+
+// SetBinary decodes value with its standard binary unmarshaling method.
+func (f *field) SetBinary(buf *buffer, rv reflect.Value, n int) error {
+	p, err := f.unframe(buf, n)
+	if err != nil {
+		return err
+	}
+
+	ptr := reflect.New(baseType(f.rt))
+
+	switch v := ptr.Interface().(type) {
+	case encoding.BinaryUnmarshaler:
+		err = v.UnmarshalBinary(p)
+	case io.ReaderFrom:
+		_, err = v.ReadFrom(bytes.NewReader(p))
+	default:
+		err = fmt.Errorf("%s does not implement encoding.BinaryUnmarshaler or io.ReaderFrom", f.rt)
+	}
+	if err != nil {
+		return err
+	}
+
+	if rv.Kind() == reflect.Ptr {
+		rv.Set(ptr)
+	} else {
+		rv.Set(ptr.Elem())
+	}
+
+	return nil
+}
+
+// unframe returns bytes of opaque value, length is taken from prefix or len tag, size tag, otherwise value takes all remaining bytes.
+func (f *field) unframe(buf *buffer, n int) ([]byte, error) {
+	switch {
+	case n >= 0:
+		return buf.next(n)
+	case f.size != 0:
+		return buf.next(f.size)
+	}
+
+	return buf.rest()
+}
+
+// opaque reports whether value is encoded by methods of its type or by registered codec, so its length is known only after encoding.
+func (f *field) opaque() bool {
+	return f.binary || f.tc != nil
+}
+
+//
+// io.WriterTo and io.ReaderFrom
+
+// WriteTo encodes struct and writes it to w.
+func (s *Struct) WriteTo(w io.Writer) (int64, error) {
+	n, err := s.c.size(s.rv)
+	if err != nil {
+		return 0, err
+	}
+
+	b, err := s.c.read(make([]byte, 0, n), s.rv)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err = w.Write(b)
+	return int64(n), err
+}
+
+// ReadFrom decodes struct from r, it reads exactly as many bytes as fields need.
+func (s *Struct) ReadFrom(r io.Reader) (int64, error) {
+	n, err := s.c.write(&buffer{r: r}, s.rv)
+	return int64(n), err
+}
@@ -0,0 +1,240 @@
+package stob
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// BinaryPoint implements encoding.BinaryAppender and encoding.BinaryUnmarshaler.
+type BinaryPoint struct {
+	X, Y int16
+}
+
+func (p BinaryPoint) AppendBinary(b []byte) ([]byte, error) {
+	b = binary.BigEndian.AppendUint16(b, uint16(p.X))
+	return binary.BigEndian.AppendUint16(b, uint16(p.Y)), nil
+}
+
+func (p *BinaryPoint) UnmarshalBinary(data []byte) error {
+	if len(data) != 4 {
+		return errors.New("invalid point")
+	}
+
+	p.X = int16(binary.BigEndian.Uint16(data))
+	p.Y = int16(binary.BigEndian.Uint16(data[2:]))
+	return nil
+}
+
+// BinaryText implements io.WriterTo and io.ReaderFrom.
+type BinaryText struct {
+	Lines []string
+}
+
+func (t BinaryText) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, strings.Join(t.Lines, "\n"))
+	return int64(n), err
+}
+
+func (t *BinaryText) ReadFrom(r io.Reader) (int64, error) {
+	data, err := io.ReadAll(r)
+	t.Lines = strings.Split(string(data), "\n")
+	return int64(len(data)), err
+}
+
+type BinaryStruct struct {
+	Addr    netip.Addr   `prefix:"u8"`
+	Ptr     *netip.Addr  `prefix:"u8"`
+	Point   BinaryPoint  `size:"4"`
+	Points  *BinaryPoint `prefix:"u16,be"`
+	PortLen uint8
+	Port    netip.AddrPort `len:"PortLen"`
+	Text    BinaryText
+}
+
+func TestBinary(t *testing.T) {
+	addr := netip.MustParseAddr("10.0.0.1")
+
+	a := BinaryStruct{
+		Addr:   netip.MustParseAddr("2001:db8::1"),
+		Ptr:    &addr,
+		Point:  BinaryPoint{X: 1, Y: -1},
+		Points: &BinaryPoint{X: 2, Y: 3},
+		Port:   netip.MustParseAddrPort("192.168.1.1:8080"),
+		Text:   BinaryText{Lines: []string{"a", "b"}},
+	}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []byte{
+		16, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01,
+		4, 10, 0, 0, 1,
+		0, 1, 0xff, 0xff,
+		0, 4, 0, 2, 0, 3,
+		6, 192, 168, 1, 1, 0x90, 0x1f,
+		'a', '\n', 'b',
+	}
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	if n, err := SizeOf(&a); err != nil || n != len(data) {
+		t.Errorf("SizeOf returns %d, %v, expected %d", n, err, len(data))
+	}
+
+	var b BinaryStruct
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	a.PortLen = 6
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", a, b)
+	}
+
+	// field without size, prefix or len takes all remaining bytes
+	if _, err := NewStruct(&struct {
+		Ptr  *netip.Addr
+		Last byte
+	}{}); err == nil || !strings.Contains(err.Error(), "takes all remaining bytes") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestBinarySize(t *testing.T) {
+	type sized struct {
+		Text BinaryText `size:"3"`
+		Tail byte
+	}
+
+	a := sized{Text: BinaryText{Lines: []string{"a", "b"}}, Tail: 1}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte{'a', '\n', 'b', 1}) {
+		t.Errorf("unexpected data % 02x", data)
+	}
+
+	var b sized
+	if err := Unmarshal(data, &b); err != nil || !reflect.DeepEqual(a, b) {
+		t.Errorf("unexpected struct %+v, %v", b, err)
+	}
+
+	a.Text.Lines = []string{"abcd"}
+	if _, err := Marshal(&a); err == nil || !strings.Contains(err.Error(), "size is 3") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	type counted struct {
+		Text BinaryText `num:"3"`
+	}
+	if _, err := NewStruct(&counted{}); err == nil {
+		t.Error("num tag is accepted")
+	}
+}
+
+func TestStructWriteToReadFrom(t *testing.T) {
+	a := CodecStruct{Name: "name", ID: 1, Ptr: &SubCodecStruct{A: 2}}
+
+	s, err := NewStruct(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var w bytes.Buffer
+	n, err := s.WriteTo(&w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(n) != w.Len() {
+		t.Errorf("WriteTo returns %d, written %d", n, w.Len())
+	}
+
+	w.WriteString("next")
+
+	var b CodecStruct
+	s, err = NewStruct(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := s.ReadFrom(&w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != n {
+		t.Errorf("ReadFrom returns %d, expected %d", m, n)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", a, b)
+	}
+	if w.String() != "next" {
+		t.Errorf("ReadFrom reads too much, left %q", w.String())
+	}
+}
+
+// BinaryHalf implements only decoding method, so it is encoded by its fields.
+type BinaryHalf struct {
+	A uint16
+}
+
+func (h *BinaryHalf) UnmarshalBinary(data []byte) error {
+	return errors.New("not used")
+}
+
+// BinaryWriter implements only io.WriterTo, as messages writing themselves to streams do.
+type BinaryWriter struct {
+	B uint8
+}
+
+func (w BinaryWriter) WriteTo(io.Writer) (int64, error) {
+	return 0, errors.New("not used")
+}
+
+func TestBinaryOneDirection(t *testing.T) {
+	type message struct {
+		Half   BinaryHalf
+		Writer BinaryWriter
+	}
+
+	a := message{Half: BinaryHalf{A: 1}, Writer: BinaryWriter{B: 2}}
+	data, err := Marshal(&a)
+	if err != nil || !bytes.Equal(data, []byte{1, 0, 2}) {
+		t.Fatalf("unexpected data % 02x, %v", data, err)
+	}
+
+	var b message
+	if err := Unmarshal(data, &b); err != nil || b != a {
+		t.Errorf("unexpected struct %+v, %v", b, err)
+	}
+}
+
+func TestBinaryElements(t *testing.T) {
+	type addrs struct {
+		N     uint8
+		Addrs []netip.Addr `count:"N"`
+	}
+	type times struct {
+		Times [2]*time.Time
+	}
+	type hidden struct {
+		Items []struct{ x int }
+	}
+
+	for _, x := range []interface{}{&addrs{}, &times{}, &hidden{}} {
+		if _, err := NewStruct(x); err == nil {
+			t.Errorf("%T: elements are accepted", x)
+		}
+	}
+}
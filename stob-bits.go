@@ -0,0 +1,174 @@
+package stob
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// bitGroup is the storage unit shared by consecutive bit fields, tag `bits:"4"`.
+// Size of unit is the size of the first field in group, fields are placed from the most significant bit, or from the least significant bit with tag `bits:"4,lsb"`.
+type bitGroup struct {
+	fields []*field
+	size   int
+	used   int
+	lsb    bool
+	e      ByteOrder
+}
+
+// parseBits parses value of bits tag: width of field and optional bit order msb or lsb.
+func (f *field) parseBits(tag string) error {
+	switch f.rk {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Bool:
+	default:
+		return fmt.Errorf("stob: bits tag of field %s is allowed only for integers and bools", f.rsf.Name)
+	}
+
+	if f.enc != 0 {
+		return fmt.Errorf("stob: bit field %s can not have enc tag", f.rsf.Name)
+	}
+
+	if f.size > 8 {
+		return fmt.Errorf("stob: size of bit field %s is larger than 8 bytes", f.rsf.Name)
+	}
+
+	opts := strings.Split(tag, ",")
+
+	width, err := strconv.Atoi(opts[0])
+	if err != nil || width <= 0 || width > int(f.rt.Size())*8 {
+		return fmt.Errorf("stob: invalid bits %q of field %s", tag, f.rsf.Name)
+	}
+	f.bits = width
+
+	for _, opt := range opts[1:] {
+		switch opt {
+		case "msb":
+			f.lsb = false
+		case "lsb":
+			f.lsb = true
+		default:
+			return fmt.Errorf("stob: unknown bit order %q of field %s", opt, f.rsf.Name)
+		}
+	}
+
+	return nil
+}
+
+// newBitGroup returns field of new bit group started by field m.
+func newBitGroup(m *field) *field {
+	f := new(field)
+	f.rsf = m.rsf
+	f.rt = m.rt
+	f.rk = m.rk
+	f.index = m.index
+	f.size = m.size
+	f.len = m.size
+	f.pad = m.pad
+	f.align = m.align
+
+	f.group = &bitGroup{
+		size: m.size,
+		lsb:  m.lsb,
+		e:    m.e,
+	}
+
+	return f
+}
+
+// has reports whether field of struct with index is member of group.
+func (g *bitGroup) has(index int) bool {
+	for _, m := range g.fields {
+		if m.index == index {
+			return true
+		}
+	}
+	return false
+}
+
+// add places field m to group, returns false if there is no room for it.
+func (g *bitGroup) add(m *field) bool {
+	if g.used+m.bits > g.size*8 {
+		return false
+	}
+
+	if g.lsb {
+		m.shift = g.used
+	} else {
+		m.shift = g.size*8 - g.used - m.bits
+	}
+
+	g.used += m.bits
+	g.fields = append(g.fields, m)
+
+	return true
+}
+
+// encode packs bit fields of struct sv.
+func (g *bitGroup) encode(b []byte, sv reflect.Value) []byte {
+	var x uint64
+
+	for _, m := range g.fields {
+		rv := m.value(sv)
+
+		var u uint64
+		switch {
+		case m.rk == reflect.Bool:
+			if rv.Bool() {
+				u = 1
+			}
+		case rv.CanInt():
+			u = uint64(rv.Int())
+		default:
+			u = rv.Uint()
+		}
+
+		x |= (u & bitMask(m.bits)) << uint(m.shift)
+	}
+
+	b, p := extend(b, g.size)
+	Itob(p, int64(x), g.e)
+
+	return b
+}
+
+// decode unpacks bit fields to struct sv.
+func (g *bitGroup) decode(buf *buffer, sv reflect.Value) error {
+	p, err := buf.next(g.size)
+	if err != nil {
+		return err
+	}
+
+	x := uint64(Btoi(p, g.e))
+
+	for _, m := range g.fields {
+		rv := m.value(sv)
+		u := (x >> uint(m.shift)) & bitMask(m.bits)
+
+		// blank bit field is reserved bits
+		if !rv.CanSet() {
+			continue
+		}
+
+		switch {
+		case m.rk == reflect.Bool:
+			rv.SetBool(u != 0)
+		case rv.CanInt():
+			// sign extension
+			rv.SetInt(int64(u<<uint(64-m.bits)) >> uint(64-m.bits))
+		default:
+			rv.SetUint(u)
+		}
+	}
+
+	return nil
+}
+
+func bitMask(bits int) uint64 {
+	if bits >= 64 {
+		return ^uint64(0)
+	}
+	return 1<<uint(bits) - 1
+}
@@ -0,0 +1,114 @@
+package stob
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type BitsStruct struct {
+	Version        byte `bits:"4"`
+	IHL            byte `bits:"4"`
+	ToS            byte
+	Flags          uint16 `bits:"3" bo:"be"`
+	FragmentOffset uint16 `bits:"13"`
+}
+
+func TestBits(t *testing.T) {
+	data := []byte{0x45, 0x00, 0x40, 0x12}
+
+	var a BitsStruct
+	if err := Unmarshal(data, &a); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := BitsStruct{Version: 4, IHL: 5, Flags: 2, FragmentOffset: 0x12}
+	if a != expect {
+		t.Errorf("unexpected decoded struct\n%+v\n%+v", a, expect)
+	}
+
+	b, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", b, data)
+	}
+
+	s, err := NewStruct(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.c.fields) != 3 || s.c.len != 4 {
+		t.Errorf("unexpected fields %d or size %d", len(s.c.fields), s.c.len)
+	}
+}
+
+type BitsLSBStruct struct {
+	A    uint16 `bits:"3,lsb"`
+	B    int16  `bits:"5"`
+	C    bool   `bits:"1"`
+	D    uint16 `bits:"7"`
+	E    uint8  `bits:"6"`
+	F    uint8  `bits:"4"`
+	Tail byte
+}
+
+func TestBitsLSB(t *testing.T) {
+	a := BitsLSBStruct{A: 5, B: -3, C: true, D: 0x55, E: 0x3f, F: 0x0a, Tail: 0xff}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A=101, B=11101, C=1, D=1010101 -> 0xabed little endian
+	// E does not share byte with F, F starts new group
+	expect := []byte{0xed, 0xab, 0xfc, 0xa0, 0xff}
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	var b BitsLSBStruct
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", a, b)
+	}
+}
+
+func TestBitsTruncate(t *testing.T) {
+	a := BitsStruct{Version: 0xff, IHL: 0x01}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 0xf1 {
+		t.Errorf("unexpected first byte %02x", data[0])
+	}
+}
+
+func TestBitsTag(t *testing.T) {
+	type wide struct {
+		A uint8 `bits:"9"`
+	}
+	if _, err := NewStruct(&wide{}); err == nil {
+		t.Error("expected error for width larger than type")
+	}
+
+	type kind struct {
+		A string `bits:"4"`
+	}
+	if _, err := NewStruct(&kind{}); err == nil {
+		t.Error("expected error for bits on string")
+	}
+
+	type order struct {
+		A uint8 `bits:"4,xyz"`
+	}
+	if _, err := NewStruct(&order{}); err == nil {
+		t.Error("expected error for unknown bit order")
+	}
+}
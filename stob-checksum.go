@@ -0,0 +1,314 @@
+package stob
+
+import (
+	"fmt"
+	"hash/adler32"
+	"hash/crc32"
+	"reflect"
+	"strings"
+)
+
+// ChecksumError is returned by decoding if checksum stored in field does not match bytes of its range.
+type ChecksumError struct {
+	Algorithm string
+	Stored    uint64
+	Computed  uint64
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch: stored %#x, computed %#x", e.Algorithm, e.Stored, e.Computed)
+}
+
+// checksumAlg is the algorithm of checksum, size is length of its value in bytes.
+type checksumAlg struct {
+	name string
+	size int
+	sum  func(p []byte) uint64
+}
+
+var checksumAlgs = map[string]*checksumAlg{}
+
+func init() {
+	for _, alg := range []*checksumAlg{
+		{"crc8", 1, newCRC(8, 0x07, 0, false).sum},
+		{"crc8-maxim", 1, newCRC(8, 0x8c, 0, true).sum},
+		{"crc16", 2, newCRC(16, 0xa001, 0, true).sum},
+		{"crc16-ccitt", 2, newCRC(16, 0x1021, 0xffff, false).sum},
+		{"crc16-modbus", 2, newCRC(16, 0xa001, 0xffff, true).sum},
+		{"crc16-xmodem", 2, newCRC(16, 0x1021, 0, false).sum},
+		{"crc32", 4, tableSum(crc32.IEEETable)},
+		{"crc32c", 4, tableSum(crc32.MakeTable(crc32.Castagnoli))},
+		{"inet16", 2, inet16},
+		{"adler32", 4, func(p []byte) uint64 { return uint64(adler32.Checksum(p)) }},
+		{"fletcher16", 2, fletcher16},
+	} {
+		checksumAlgs[alg.name] = alg
+	}
+}
+
+// crc is table-driven CRC of width 8 or 16 bits, polynomial of reflected one is reversed too.
+type crc struct {
+	width uint
+	init  uint32
+	refl  bool
+	table [256]uint32
+}
+
+func newCRC(width uint, poly, init uint32, refl bool) *crc {
+	c := &crc{width: width, init: init, refl: refl}
+	mask := uint32(1)<<width - 1
+
+	for i := range c.table {
+		r := uint32(i)
+		if !refl {
+			r <<= width - 8
+		}
+
+		for j := 0; j < 8; j++ {
+			switch {
+			case refl && r&1 != 0:
+				r = r>>1 ^ poly
+			case refl:
+				r >>= 1
+			case r&(1<<(width-1)) != 0:
+				r = r<<1 ^ poly
+			default:
+				r <<= 1
+			}
+		}
+
+		c.table[i] = r & mask
+	}
+
+	return c
+}
+
+func (c *crc) sum(p []byte) uint64 {
+	r := c.init
+	for _, b := range p {
+		if c.refl {
+			r = r>>8 ^ c.table[byte(r)^b]
+		} else {
+			r = (r<<8 ^ c.table[byte(r>>(c.width-8))^b]) & (1<<c.width - 1)
+		}
+	}
+	return uint64(r)
+}
+
+// tableSum returns CRC-32 function of table t.
+func tableSum(t *crc32.Table) func(p []byte) uint64 {
+	return func(p []byte) uint64 {
+		return uint64(crc32.Checksum(p, t))
+	}
+}
+
+// inet16 is the Internet checksum of RFC 1071: one's complement of one's complement sum of big-endian 16-bit words.
+func inet16(p []byte) uint64 {
+	var s uint32
+	for i := 0; i+1 < len(p); i += 2 {
+		s += uint32(p[i])<<8 | uint32(p[i+1])
+	}
+	if len(p)%2 != 0 {
+		s += uint32(p[len(p)-1]) << 8
+	}
+
+	for s > 0xffff {
+		s = s>>16 + s&0xffff
+	}
+
+	return uint64(^s & 0xffff)
+}
+
+func fletcher16(p []byte) uint64 {
+	var a, b uint32
+	for _, c := range p {
+		a = (a + uint32(c)) % 255
+		b = (b + a) % 255
+	}
+	return uint64(b<<8 | a)
+}
+
+//
+// checksum field
+
+// checksum is the integer field holding checksum of bytes of fields from..to of the same struct, tags `checksum:"crc32,range=Header"` or `checksum:"inet16,from=Version,to=Dst"`. Without range it covers the whole struct, bytes of the checksum field itself are zero on computing.
+type checksum struct {
+	alg      *checksumAlg
+	from, to string
+
+	// first and last are indexes of fields of range in codec
+	first, last int
+}
+
+// parseChecksum parses value of checksum tag.
+func (f *field) parseChecksum(tag string) error {
+	opts := strings.Split(tag, ",")
+
+	alg, ok := checksumAlgs[opts[0]]
+	if !ok {
+		return fmt.Errorf("stob: field %s: unknown checksum %q", f.rsf.Name, opts[0])
+	}
+
+	switch f.rk {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return fmt.Errorf("stob: checksum tag of field %s is allowed only for integers", f.rsf.Name)
+	}
+
+	if f.bits != 0 || f.enc != 0 {
+		return fmt.Errorf("stob: checksum field %s can not have bits or enc tags", f.rsf.Name)
+	}
+
+	cs := &checksum{alg: alg}
+	for _, opt := range opts[1:] {
+		key, name, _ := strings.Cut(opt, "=")
+		switch key {
+		case "range":
+			cs.from, cs.to = name, name
+		case "from":
+			cs.from = name
+		case "to":
+			cs.to = name
+		default:
+			return fmt.Errorf("stob: field %s: invalid checksum option %q", f.rsf.Name, opt)
+		}
+	}
+
+	f.sum = cs
+	return nil
+}
+
+// resolve looks up fields of range in codec c, it is called after fields of checksum field f are compiled.
+func (cs *checksum) resolve(c *Codec, f *field) (err error) {
+	if f.len < cs.alg.size {
+		return fmt.Errorf("stob: %s checksum does not fit %d bytes of field %s", cs.alg.name, f.len, f.rsf.Name)
+	}
+
+	cs.first, cs.last = 0, len(c.fields)-1
+
+	if cs.from != "" {
+		if cs.first, err = c.fieldIndex(cs.from); err != nil {
+			return fmt.Errorf("stob: checksum field %s: %s", f.rsf.Name, err)
+		}
+	}
+	if cs.to != "" {
+		if cs.last, err = c.fieldIndex(cs.to); err != nil {
+			return fmt.Errorf("stob: checksum field %s: %s", f.rsf.Name, err)
+		}
+	}
+
+	if cs.first > cs.last {
+		return fmt.Errorf("stob: checksum field %s: range starts after its end", f.rsf.Name)
+	}
+
+	return nil
+}
+
+// fieldIndex returns index of encoded field by its name, members of bit group are referred by the group.
+func (c *Codec) fieldIndex(name string) (int, error) {
+	sf, ok := c.rt.FieldByName(name)
+	if !ok || len(sf.Index) != 1 {
+		return 0, fmt.Errorf("unknown field %s", name)
+	}
+
+	for i := len(c.fields) - 1; i >= 0; i-- {
+		f := c.fields[i]
+		if f.index > sf.Index[0] {
+			continue
+		}
+
+		if f.index == sf.Index[0] || f.group != nil && f.group.has(sf.Index[0]) {
+			return i, nil
+		}
+		break
+	}
+
+	return 0, fmt.Errorf("field %s is not encoded", name)
+}
+
+// span is the bytes of field, offsets are in the whole encoded or decoded data.
+type span struct {
+	start, end int
+}
+
+// span returns bytes of field of struct sv, which starts at start. Field is placed after offset and ends at end, its padding is not included.
+func (f *field) span(sv reflect.Value, start, offset, end int) span {
+	if f.cond != nil && !f.cond.eval(sv) {
+		return span{offset, offset}
+	}
+
+	off := offset - start
+	if f.pos != nil {
+		off, _ = f.pos.get(sv)
+	}
+
+	return span{start + off + f.padding(off), end}
+}
+
+// putSums computes checksums over encoded fields and stores them to b.
+func (c *Codec) putSums(b []byte, spans []span) {
+	for i, f := range c.fields {
+		if f.sum == nil || spans[i].start == spans[i].end {
+			continue
+		}
+
+		p := b[spans[i].start:spans[i].end]
+		for j := range p {
+			p[j] = 0
+		}
+
+		x := f.sum.alg.sum(b[spans[f.sum.first].start:spans[f.sum.last].end])
+		Itob(p, int64(x), f.e)
+	}
+}
+
+// checkSums verifies checksums of decoded fields.
+func (c *Codec) checkSums(buf *buffer, spans []span) error {
+	for i, f := range c.fields {
+		if f.sum == nil || spans[i].start == spans[i].end {
+			continue
+		}
+
+		self, err := buf.bytes(spans[i])
+		if err != nil {
+			return fieldError(err, f.rsf.Name, spans[i].start)
+		}
+
+		r := span{spans[f.sum.first].start, spans[f.sum.last].end}
+		p, err := buf.bytes(r)
+		if err != nil {
+			return fieldError(err, f.rsf.Name, spans[i].start)
+		}
+
+		// bytes of checksum are zero on computing
+		p = append([]byte(nil), p...)
+		for j := spans[i].start; j < spans[i].end; j++ {
+			if j >= r.start && j < r.end {
+				p[j-r.start] = 0
+			}
+		}
+
+		mask := ^uint64(0)
+		if len(self) < 8 {
+			mask = 1<<(uint(len(self))*8) - 1
+		}
+
+		stored := uint64(Btoi(self, f.e)) & mask
+		computed := f.sum.alg.sum(p) & mask
+		if stored != computed {
+			return fieldError(&ChecksumError{Algorithm: f.sum.alg.name, Stored: stored, Computed: computed}, f.rsf.Name, spans[i].start)
+		}
+	}
+
+	return nil
+}
+
+// bytes returns decoded bytes of span s.
+func (buf *buffer) bytes(s span) ([]byte, error) {
+	if s.start < buf.base || s.start > s.end || s.end > buf.base+len(buf.p) {
+		return nil, fmt.Errorf("bytes %d..%d are not available for checksum", s.start, s.end)
+	}
+
+	return buf.p[s.start-buf.base : s.end-buf.base], nil
+}
@@ -0,0 +1,158 @@
+package stob
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChecksumAlgorithms(t *testing.T) {
+	check := []byte("123456789")
+
+	for _, test := range []struct {
+		name string
+		p    []byte
+		sum  uint64
+	}{
+		{"crc8", check, 0xf4},
+		{"crc8-maxim", check, 0xa1},
+		{"crc16", check, 0xbb3d},
+		{"crc16-ccitt", check, 0x29b1},
+		{"crc16-modbus", check, 0x4b37},
+		{"crc16-xmodem", check, 0x31c3},
+		{"crc32", check, 0xcbf43926},
+		{"crc32c", check, 0xe3069283},
+		{"adler32", check, 0x091e01de},
+		{"fletcher16", []byte("abcde"), 0xc8f0},
+		{"inet16", []byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7}, 0x220d},
+		{"inet16", []byte{0x01}, 0xfeff},
+	} {
+		if sum := checksumAlgs[test.name].sum(test.p); sum != test.sum {
+			t.Errorf("%s(%q) = %#x, expected %#x", test.name, test.p, sum, test.sum)
+		}
+	}
+}
+
+type ChecksumHeader struct {
+	Version uint8 `bits:"4"`
+	IHL     uint8 `bits:"4"`
+	ToS     uint8
+	Length  uint16
+	Sum     uint16 `bo:"be" checksum:"inet16"`
+	Src     [4]byte
+}
+
+type ChecksumFrame struct {
+	Type    uint8
+	Header  ChecksumHeader
+	CRC     uint32 `checksum:"crc32,range=Header"`
+	Payload []byte `prefix:"u8"`
+	Adler   uint32 `bo:"be" checksum:"adler32,from=Type,to=Payload"`
+}
+
+func TestChecksum(t *testing.T) {
+	a := ChecksumFrame{
+		Type:    1,
+		Header:  ChecksumHeader{Version: 4, IHL: 5, Length: 20, Src: [4]byte{10, 0, 0, 1}},
+		Payload: []byte("data"),
+	}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := data[1:11]
+	if sum := inet16(header); sum != 0 {
+		t.Errorf("inet16 of header with checksum is %#x, expected 0", sum)
+	}
+	if crc := uint64(Btoi(data[11:15], LittleEndian)); crc != checksumAlgs["crc32"].sum(header) {
+		t.Errorf("unexpected crc32 %#x", crc)
+	}
+	if adler := uint64(Btoi(data[20:], BigEndian)); adler != checksumAlgs["adler32"].sum(data[:20]) {
+		t.Errorf("unexpected adler32 %#x", adler)
+	}
+
+	var b ChecksumFrame
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Payload == nil || b.Header.Sum == 0 || b.CRC == 0 || b.Adler == 0 {
+		t.Errorf("checksums are not decoded %+v", b)
+	}
+
+	// encoding ignores values of checksum fields
+	again, err := Marshal(&b)
+	if err != nil || !bytes.Equal(again, data) {
+		t.Errorf("unexpected data % 02x, %v", again, err)
+	}
+
+	var c ChecksumFrame
+	if err := NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil || !reflect.DeepEqual(b, c) {
+		t.Errorf("unexpected struct decoded from stream %+v, %v", c, err)
+	}
+
+	// payload is corrupted
+	data[17] ^= 0xff
+
+	err = Unmarshal(data, &b)
+	var ce *ChecksumError
+	if !errors.As(err, &ce) || ce.Algorithm != "adler32" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if fe, ok := err.(*FieldError); !ok || fe.Path != "Adler" || fe.Offset != 20 {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if err := (&Options{SkipChecksum: true}).Unmarshal(data, &b); err != nil {
+		t.Errorf("checksum is verified: %v", err)
+	}
+
+	// header is corrupted, nested checksum fails first
+	data[17] ^= 0xff
+	data[3] ^= 0x01
+
+	err = Unmarshal(data, &b)
+	if fe, ok := err.(*FieldError); !ok || fe.Path != "Header.Sum" || !strings.Contains(err.Error(), "inet16 checksum mismatch") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestChecksumErrors(t *testing.T) {
+	for _, test := range []struct {
+		x   interface{}
+		err string
+	}{
+		{&struct {
+			A uint8 `checksum:"md5"`
+		}{}, `unknown checksum "md5"`},
+		{&struct {
+			A []byte `checksum:"crc8"`
+		}{}, "allowed only for integers"},
+		{&struct {
+			A uint16 `checksum:"crc32"`
+		}{}, "crc32 checksum does not fit 2 bytes"},
+		{&struct {
+			A uint16 `checksum:"crc16,range=B"`
+		}{}, "unknown field B"},
+		{&struct {
+			A uint8
+			B uint8
+			C uint16 `checksum:"crc16,from=B,to=A"`
+		}{}, "range starts after its end"},
+		{&struct {
+			A uint16 `checksum:"crc16,over=B"`
+		}{}, `invalid checksum option "over=B"`},
+		{&struct {
+			A uint8 `stob:"-"`
+			B uint8 `checksum:"crc8,range=A"`
+		}{}, "field A is not encoded"},
+	} {
+		_, err := NewStruct(test.x)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%T: expected error %q, got %v", test.x, test.err, err)
+		}
+	}
+}
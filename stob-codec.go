@@ -0,0 +1,143 @@
+package stob
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Compile returns codec of struct type rt. Codecs are compiled once per type and cached, codec is immutable and safe for concurrent use.
+func Compile(rt reflect.Type) (*Codec, error) {
+	return defaultOptions.Compile(rt)
+}
+
+// Type returns struct type of codec.
+func (c *Codec) Type() reflect.Type {
+	return c.rt
+}
+
+// Encode struct x to bytes, x should be struct or pointer to struct of codec type.
+func (c *Codec) Encode(x interface{}) ([]byte, error) {
+	rv, err := c.value(x, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.read(nil, rv)
+}
+
+// Decode bytes to struct x, x should be pointer to struct of codec type. Returns count of decoded bytes.
+func (c *Codec) Decode(p []byte, x interface{}) (int, error) {
+	rv, err := c.value(x, true)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.write(&buffer{p: p}, rv)
+}
+
+// value returns struct value of x.
+func (c *Codec) value(x interface{}, ptr bool) (reflect.Value, error) {
+	rv := reflect.ValueOf(x)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return rv, fmt.Errorf("stob: nil %s", rv.Type())
+		}
+		rv = rv.Elem()
+	} else if ptr {
+		return rv, fmt.Errorf("stob: non-pointer %T", x)
+	}
+
+	if rv.Type() != c.rt {
+		return rv, fmt.Errorf("stob: %T does not match codec type %s", x, c.rt)
+	}
+
+	return rv, nil
+}
+
+// TypedCodec is codec of struct type T.
+type TypedCodec[T any] struct {
+	c *Codec
+}
+
+// CodecFor returns cached codec of struct type T.
+func CodecFor[T any]() (*TypedCodec[T], error) {
+	c, err := Compile(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	return &TypedCodec[T]{c: c}, nil
+}
+
+// Codec returns untyped codec.
+func (tc *TypedCodec[T]) Codec() *Codec {
+	return tc.c
+}
+
+// Encode v to bytes.
+func (tc *TypedCodec[T]) Encode(v *T) ([]byte, error) {
+	return tc.c.Encode(v)
+}
+
+// Decode p to v, returns count of decoded bytes.
+func (tc *TypedCodec[T]) Decode(p []byte, v *T) (int, error) {
+	return tc.c.Decode(p, v)
+}
+
+// Encode returns encoded v, v should be struct or pointer to struct.
+func Encode[T any](v T) ([]byte, error) {
+	return AppendEncode(nil, v)
+}
+
+// AppendEncode appends encoded v to dst, v should be struct or pointer to struct.
+func AppendEncode[T any](dst []byte, v T) ([]byte, error) {
+	c, rv, err := typedValue(&v, false)
+	if err != nil {
+		return dst, err
+	}
+
+	if m, ok := rv.Addr().Interface().(Marshaler); ok {
+		return m.MarshalStob(dst)
+	}
+
+	return c.read(dst, rv)
+}
+
+// Decode returns value of type T decoded from p, T should be struct or pointer to struct.
+func Decode[T any](p []byte) (T, error) {
+	v, _, err := DecodeN[T](p)
+	return v, err
+}
+
+// DecodeN is like Decode, but also returns count of decoded bytes.
+func DecodeN[T any](p []byte) (v T, n int, err error) {
+	c, rv, err := typedValue(&v, true)
+	if err != nil {
+		return v, 0, err
+	}
+
+	if u, ok := rv.Addr().Interface().(Unmarshaler); ok {
+		n, err = u.UnmarshalStob(p)
+		return v, n, err
+	}
+
+	n, err = c.write(&buffer{p: p}, rv)
+	return v, n, err
+}
+
+// typedValue returns codec and addressable struct value of v, nil pointer is allocated if alloc is set.
+func typedValue[T any](v *T, alloc bool) (*Codec, reflect.Value, error) {
+	rv := reflect.ValueOf(v).Elem()
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			if !alloc {
+				return nil, rv, fmt.Errorf("stob: nil %s", rv.Type())
+			}
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+
+	c, err := Compile(rv.Type())
+	return c, rv, err
+}
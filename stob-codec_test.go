@@ -0,0 +1,229 @@
+package stob
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type CodecStruct struct {
+	Name  string `size:"8"`
+	ID    uint32 `bo:"be"`
+	Value int16
+	Flags [2]byte
+	Sub   SubCodecStruct
+	Ptr   *SubCodecStruct
+}
+
+type SubCodecStruct struct {
+	A byte
+	B uint16 `bo:"be"`
+}
+
+func TestCompileCache(t *testing.T) {
+	rt := reflect.TypeOf(CodecStruct{})
+
+	c1, err := Compile(rt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c2, err := Compile(rt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c1 != c2 {
+		t.Error("codec is not cached")
+	}
+
+	s, err := NewStruct(&CodecStruct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.c != c1 {
+		t.Error("NewStruct does not use cached codec")
+	}
+
+	if _, err := Compile(reflect.TypeOf(1)); err == nil {
+		t.Error("expected error for non-struct type")
+	}
+}
+
+type RecursiveNode struct {
+	V    int32
+	Next *RecursiveNode
+}
+
+type RecursiveList struct {
+	Items []RecursiveItem `prefix:"u8"`
+}
+
+type RecursiveItem struct {
+	List RecursiveList `bo:"be"`
+}
+
+func TestCompileRecursive(t *testing.T) {
+	for _, rt := range []reflect.Type{reflect.TypeOf(RecursiveNode{}), reflect.TypeOf(RecursiveList{})} {
+		_, err := Compile(rt)
+		if err == nil || !strings.Contains(err.Error(), "recursive type") {
+			t.Errorf("%s: expected recursive type error, got %v", rt, err)
+		}
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	c, err := CodecFor[CodecStruct]()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := CodecStruct{
+		Name:  "name",
+		ID:    0x01020304,
+		Value: 1000,
+		Flags: [2]byte{0xaa, 0xbb},
+		Sub:   SubCodecStruct{A: 1, B: 2},
+		Ptr:   &SubCodecStruct{A: 3, B: 4},
+	}
+
+	data, err := c.Encode(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []byte{
+		'n', 'a', 'm', 'e', 0, 0, 0, 0,
+		0x01, 0x02, 0x03, 0x04,
+		0xe8, 0x03,
+		0xaa, 0xbb,
+		0x01, 0x00, 0x02,
+		0x03, 0x00, 0x04,
+	}
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	var b CodecStruct
+	n, err := c.Decode(data, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) {
+		t.Error("unexpected count of decoded bytes", n, len(data))
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", a, b)
+	}
+}
+
+func TestCodecConcurrent(t *testing.T) {
+	c, err := CodecFor[CodecStruct]()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				a := CodecStruct{ID: uint32(i*1000 + j), Value: int16(j), Ptr: &SubCodecStruct{B: uint16(i)}}
+
+				data, err := c.Encode(&a)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				var b CodecStruct
+				if _, err := c.Decode(data, &b); err != nil {
+					t.Error(err)
+					return
+				}
+
+				if !reflect.DeepEqual(a, b) {
+					t.Errorf("decoded struct is not equal\n%+v\n%+v", a, b)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestCodecType(t *testing.T) {
+	c, err := Compile(reflect.TypeOf(CodecStruct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Encode(SubCodecStruct{}); err == nil {
+		t.Error("expected error for wrong type")
+	}
+
+	if _, err := c.Decode(nil, CodecStruct{}); err == nil {
+		t.Error("expected error for non-pointer")
+	}
+
+	if _, err := c.Encode(CodecStruct{Ptr: &SubCodecStruct{}}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGeneric(t *testing.T) {
+	a := CodecStruct{Name: "a", ID: 7, Sub: SubCodecStruct{A: 1}, Ptr: &SubCodecStruct{B: 2}}
+
+	data, err := Encode(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	if data, err = AppendEncode([]byte{0xff}, &a); err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 0xff || !bytes.Equal(data[1:], expect) {
+		t.Errorf("unexpected data % 02x", data)
+	}
+
+	b, err := Decode[CodecStruct](expect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", a, b)
+	}
+
+	p, n, err := DecodeN[*CodecStruct](append(expect, 1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(expect) {
+		t.Error("unexpected count of decoded bytes", n, len(expect))
+	}
+	if !reflect.DeepEqual(&a, p) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", a, p)
+	}
+
+	if _, err := Encode[*CodecStruct](nil); err == nil {
+		t.Error("nil pointer is encoded")
+	}
+	if _, err := Encode(1); err == nil {
+		t.Error("int is encoded")
+	}
+	if _, err := Decode[CodecStruct](expect[:10]); err == nil {
+		t.Error("short data is decoded")
+	}
+}
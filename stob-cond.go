@@ -0,0 +1,345 @@
+package stob
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// condition is the expression of tag `if:"Version>=2"`, field is encoded and decoded only if expression is true.
+// Expression refers to integer or bool fields placed before the field, it supports comparison, logical and bitwise operators: `if:"Flags&0x04 != 0 && Version > 1"`.
+type condition struct {
+	expr string
+	root condNode
+}
+
+type condNode interface {
+	eval(sv reflect.Value) int64
+}
+
+// parseCondition parses expression of if tag.
+func parseCondition(expr string) (*condition, error) {
+	p := &condParser{expr: expr}
+
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q in condition %q", p.toks[p.pos], expr)
+	}
+
+	return &condition{expr: expr, root: root}, nil
+}
+
+// resolve looks up fields referred by condition in struct type rt, they should be placed before field f.
+func (cond *condition) resolve(rt reflect.Type, f *field) error {
+	return resolveCond(cond.root, rt, f)
+}
+
+func resolveCond(n condNode, rt reflect.Type, f *field) error {
+	switch n := n.(type) {
+	case *condIdent:
+		sf, ok := rt.FieldByName(n.name)
+		if !ok {
+			return fmt.Errorf("stob: condition of field %s refers to unknown field %s", f.rsf.Name, n.name)
+		}
+
+		if sf.Index[0] >= f.index {
+			return fmt.Errorf("stob: condition of field %s refers to field %s which is not placed before it", f.rsf.Name, n.name)
+		}
+
+		switch sf.Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Bool:
+		default:
+			return fmt.Errorf("stob: condition of field %s refers to field %s of type %s", f.rsf.Name, n.name, sf.Type)
+		}
+
+		n.index = sf.Index
+
+	case *condUnary:
+		return resolveCond(n.x, rt, f)
+
+	case *condBinary:
+		if err := resolveCond(n.l, rt, f); err != nil {
+			return err
+		}
+		return resolveCond(n.r, rt, f)
+	}
+
+	return nil
+}
+
+// eval reports whether condition is true for struct sv.
+func (cond *condition) eval(sv reflect.Value) bool {
+	return cond.root.eval(sv) != 0
+}
+
+//
+// nodes
+
+type condIdent struct {
+	name  string
+	index []int
+}
+
+func (n *condIdent) eval(sv reflect.Value) int64 {
+	rv, err := fieldByIndex(sv, n.index)
+	if err != nil {
+		return 0
+	}
+
+	switch {
+	case rv.Kind() == reflect.Bool:
+		if rv.Bool() {
+			return 1
+		}
+		return 0
+	case rv.CanInt():
+		return rv.Int()
+	default:
+		return int64(rv.Uint())
+	}
+}
+
+type condNumber int64
+
+func (n condNumber) eval(sv reflect.Value) int64 {
+	return int64(n)
+}
+
+type condUnary struct {
+	op string
+	x  condNode
+}
+
+func (n *condUnary) eval(sv reflect.Value) int64 {
+	x := n.x.eval(sv)
+
+	switch n.op {
+	case "!":
+		return condBool(x == 0)
+	case "-":
+		return -x
+	}
+
+	return x
+}
+
+type condBinary struct {
+	op   string
+	l, r condNode
+}
+
+func (n *condBinary) eval(sv reflect.Value) int64 {
+	switch n.op {
+	case "||":
+		return condBool(n.l.eval(sv) != 0 || n.r.eval(sv) != 0)
+	case "&&":
+		return condBool(n.l.eval(sv) != 0 && n.r.eval(sv) != 0)
+	}
+
+	l, r := n.l.eval(sv), n.r.eval(sv)
+
+	switch n.op {
+	case "==":
+		return condBool(l == r)
+	case "!=":
+		return condBool(l != r)
+	case "<":
+		return condBool(l < r)
+	case "<=":
+		return condBool(l <= r)
+	case ">":
+		return condBool(l > r)
+	case ">=":
+		return condBool(l >= r)
+	case "&":
+		return l & r
+	case "|":
+		return l | r
+	}
+
+	return 0
+}
+
+func condBool(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+//
+// parser
+
+type condParser struct {
+	expr string
+	toks []string
+	pos  int
+}
+
+var condOperators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "&", "|", "!", "-", "(", ")"}
+
+func (p *condParser) tokenize() error {
+	s := p.expr
+
+	for len(s) > 0 {
+		c := s[0]
+
+		switch {
+		case c == ' ' || c == '\t':
+			s = s[1:]
+			continue
+
+		case isIdentByte(c, false):
+			// identifier or number
+			i := 1
+			for i < len(s) && isIdentByte(s[i], false) {
+				i++
+			}
+			p.toks = append(p.toks, s[:i])
+			s = s[i:]
+			continue
+		}
+
+		var op string
+		for _, o := range condOperators {
+			if strings.HasPrefix(s, o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return fmt.Errorf("unexpected %q in condition %q", c, p.expr)
+		}
+
+		p.toks = append(p.toks, op)
+		s = s[len(op):]
+	}
+
+	return nil
+}
+
+func isIdentByte(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+func (p *condParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *condParser) parseOr() (condNode, error) {
+	return p.parseBinary([]string{"||"}, p.parseAnd)
+}
+
+func (p *condParser) parseAnd() (condNode, error) {
+	return p.parseBinary([]string{"&&"}, p.parseCmp)
+}
+
+func (p *condParser) parseCmp() (condNode, error) {
+	l, err := p.parseBitOr()
+	if err != nil {
+		return nil, err
+	}
+
+	switch op := p.peek(); op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.pos++
+
+		r, err := p.parseBitOr()
+		if err != nil {
+			return nil, err
+		}
+
+		return &condBinary{op: op, l: l, r: r}, nil
+	}
+
+	return l, nil
+}
+
+func (p *condParser) parseBitOr() (condNode, error) {
+	return p.parseBinary([]string{"|"}, p.parseBitAnd)
+}
+
+func (p *condParser) parseBitAnd() (condNode, error) {
+	return p.parseBinary([]string{"&"}, p.parseUnary)
+}
+
+func (p *condParser) parseBinary(ops []string, next func() (condNode, error)) (condNode, error) {
+	l, err := next()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+
+		var ok bool
+		for _, o := range ops {
+			ok = ok || op == o
+		}
+		if !ok {
+			return l, nil
+		}
+		p.pos++
+
+		r, err := next()
+		if err != nil {
+			return nil, err
+		}
+
+		l = &condBinary{op: op, l: l, r: r}
+	}
+}
+
+func (p *condParser) parseUnary() (condNode, error) {
+	tok := p.peek()
+	if tok == "" {
+		return nil, fmt.Errorf("unexpected end of condition %q", p.expr)
+	}
+	p.pos++
+
+	switch {
+	case tok == "!" || tok == "-":
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &condUnary{op: tok, x: x}, nil
+
+	case tok == "(":
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing ) in condition %q", p.expr)
+		}
+		p.pos++
+		return x, nil
+
+	case tok[0] >= '0' && tok[0] <= '9':
+		x, err := strconv.ParseInt(tok, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in condition %q", tok, p.expr)
+		}
+		return condNumber(x), nil
+
+	case isIdentByte(tok[0], true):
+		return &condIdent{name: tok}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q in condition %q", tok, p.expr)
+}
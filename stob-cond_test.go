@@ -0,0 +1,116 @@
+package stob
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type CondStruct struct {
+	Version byte
+	Flags   uint16 `bo:"be"`
+	Ext     bool
+	A       uint32 `if:"Version>=2"`
+	B       uint16 `if:"Flags&0x04 != 0 && Version > 1"`
+	C       string `if:"Ext || (Flags & 0x8000)" prefix:"u8"`
+	D       byte   `if:"!Ext"`
+	E       byte   `if:"Flags == 0x8004 | 1"`
+}
+
+func TestCond(t *testing.T) {
+	for _, test := range []struct {
+		a    CondStruct
+		data []byte
+	}{
+		{
+			a:    CondStruct{Version: 1, A: 0, D: 7},
+			data: []byte{0x01, 0x00, 0x00, 0x00, 0x07},
+		},
+		{
+			a:    CondStruct{Version: 2, Flags: 0x0004, A: 1, B: 2, D: 7},
+			data: []byte{0x02, 0x00, 0x04, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x07},
+		},
+		{
+			a:    CondStruct{Version: 1, Flags: 0x8000, C: "c", D: 7},
+			data: []byte{0x01, 0x80, 0x00, 0x00, 0x01, 'c', 0x07},
+		},
+		{
+			a:    CondStruct{Version: 2, Flags: 0x8005, Ext: true, A: 1, B: 2, C: "c", E: 9},
+			data: []byte{0x02, 0x80, 0x05, 0x01, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01, 'c', 0x09},
+		},
+	} {
+		data, err := Marshal(&test.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, test.data) {
+			t.Errorf("unexpected data\n% 02x\n% 02x", data, test.data)
+		}
+
+		b := CondStruct{A: 0xff, B: 0xff, C: "x", D: 0xff, E: 0xff}
+		if err := Unmarshal(data, &b); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(b, test.a) {
+			t.Errorf("decoded struct is not equal\n%+v\n%+v", b, test.a)
+		}
+	}
+}
+
+func TestCondSkipValue(t *testing.T) {
+	a := CondStruct{Version: 1, A: 0xffffffff, B: 0xffff, D: 1}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 5 {
+		t.Errorf("skipped fields are encoded % 02x", data)
+	}
+}
+
+func TestCondSize(t *testing.T) {
+	s, err := NewStruct(&CondStruct{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.c.len != 4 {
+		t.Errorf("conditional fields are counted in size %d", s.c.len)
+	}
+}
+
+func TestCondTag(t *testing.T) {
+	for _, x := range []interface{}{
+		&struct {
+			A byte `if:"B > 1"`
+			B byte
+		}{},
+		&struct {
+			A byte `if:"X > 1"`
+		}{},
+		&struct {
+			S string
+			A byte `if:"S"`
+		}{},
+		&struct {
+			V byte
+			A byte `if:"V >"`
+		}{},
+		&struct {
+			V byte
+			A byte `if:"(V > 1"`
+		}{},
+		&struct {
+			V byte
+			A byte `if:"V # 1"`
+		}{},
+		&struct {
+			V byte
+			A byte `if:"V > 0xzz"`
+		}{},
+	} {
+		if _, err := NewStruct(x); err == nil {
+			t.Errorf("expected error for %T", x)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package stob
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type EmbeddedHeader struct {
+	Type   uint8
+	Length uint16
+}
+
+type EmbeddedInner struct {
+	A uint16
+	B uint32 `bo:"le"`
+	EmbeddedHeader
+}
+
+type EmbeddedFlags uint16
+
+type embeddedHidden struct {
+	X uint16
+}
+
+type EmbeddedMessage struct {
+	EmbeddedInner `bo:"be"`
+	*EmbeddedHeader
+	EmbeddedFlags
+	embeddedHidden
+	Data []byte `len:"Length"`
+}
+
+func TestEmbedded(t *testing.T) {
+	a := EmbeddedMessage{
+		EmbeddedInner: EmbeddedInner{
+			A:              0x0102,
+			B:              0x03040506,
+			EmbeddedHeader: EmbeddedHeader{Type: 7, Length: 5},
+		},
+		EmbeddedHeader: &EmbeddedHeader{Type: 8, Length: 2},
+		EmbeddedFlags:  0x0c0d,
+		embeddedHidden: embeddedHidden{X: 0x0e0f},
+		Data:           []byte{0xaa, 0xbb},
+	}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []byte{
+		0x01, 0x02, // A, big endian by embedding tag
+		0x06, 0x05, 0x04, 0x03, // B, own tag wins
+		7, 0x00, 0x05, // nested embed inherits big endian
+		8, 0x02, 0x00, // pointer embed, its Length is promoted as shallower
+		0x0d, 0x0c, // non-struct embed is plain field
+		0x0f, 0x0e, // exported fields of unexported embed
+		0xaa, 0xbb, // length from promoted field
+	}
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	var b EmbeddedMessage
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", a, b)
+	}
+}
+
+func TestEmbeddedNil(t *testing.T) {
+	type message struct {
+		*EmbeddedHeader `bo:"be"`
+		Value           uint8
+	}
+
+	data, err := Marshal(&message{Value: 1})
+	if err != nil || !bytes.Equal(data, []byte{0, 0, 0, 1}) {
+		t.Fatalf("nil embed is not encoded as zero struct: % 02x, %v", data, err)
+	}
+
+	var b message
+	if err := Unmarshal([]byte{1, 0, 2, 3}, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.EmbeddedHeader == nil || *b.EmbeddedHeader != (EmbeddedHeader{Type: 1, Length: 2}) || b.Value != 3 {
+		t.Errorf("unexpected struct %+v %+v", b, b.EmbeddedHeader)
+	}
+}
+
+func TestEmbeddedCodecs(t *testing.T) {
+	type message struct {
+		Big    EmbeddedHeader `bo:"be"`
+		Little EmbeddedHeader
+	}
+
+	// the same type is compiled separately for inherited byte order
+	a := message{Big: EmbeddedHeader{1, 2}, Little: EmbeddedHeader{3, 4}}
+	data, err := Marshal(&a)
+	if err != nil || !bytes.Equal(data, []byte{1, 0, 2, 3, 4, 0}) {
+		t.Fatalf("unexpected data % 02x, %v", data, err)
+	}
+
+	if data, err := Marshal(&a.Little); err != nil || !bytes.Equal(data, []byte{3, 4, 0}) {
+		t.Errorf("unexpected data % 02x, %v", data, err)
+	}
+}
+
+func TestEmbeddedNilRef(t *testing.T) {
+	type message struct {
+		*EmbeddedHeader
+		Data []byte `len:"Length"`
+	}
+
+	a := message{Data: []byte{1, 2}}
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte{0, 2, 0, 1, 2}) {
+		t.Errorf("unexpected data % 02x", data)
+	}
+
+	// struct passed by value is not changed
+	if data, err := AppendMarshal(nil, message{Data: []byte{3}}); err != nil || !bytes.Equal(data, []byte{0, 1, 0, 3}) {
+		t.Errorf("unexpected data % 02x, %v", data, err)
+	}
+
+	var b message
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.EmbeddedHeader == nil || b.Length != 2 || !bytes.Equal(b.Data, a.Data) {
+		t.Errorf("unexpected struct %+v", b)
+	}
+}
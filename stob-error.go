@@ -0,0 +1,71 @@
+package stob
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FieldError is the error of encoding or decoding field.
+type FieldError struct {
+	// Path is the dotted path of field from the top-level struct, e.g. IPv4Header.Length, elements of slices are referred by index: Items[2].Name
+	Path string
+
+	// Offset is the byte offset where error occurred, -1 if it is unknown
+	Offset int
+
+	// Need and Have are the expected and available count of bytes, both are 0 if error is not caused by short data
+	Need int
+	Have int
+
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("stob:")
+
+	if e.Path != "" {
+		sb.WriteString(" field ")
+		sb.WriteString(e.Path)
+	}
+	if e.Offset >= 0 {
+		fmt.Fprintf(&sb, " at offset %d", e.Offset)
+	}
+	if e.Need != 0 || e.Have != 0 {
+		fmt.Fprintf(&sb, ": need %d bytes, have %d", e.Need, e.Have)
+	}
+
+	sb.WriteString(": ")
+	sb.WriteString(e.Err.Error())
+
+	return sb.String()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// fieldError returns err as FieldError with name prepended to its path, offset is used if err does not know it.
+func fieldError(err error, name string, offset int) error {
+	fe, ok := err.(*FieldError)
+	if !ok {
+		return &FieldError{Path: name, Offset: offset, Err: err}
+	}
+
+	switch {
+	case fe.Path == "":
+		fe.Path = name
+	case fe.Path[0] == '[':
+		fe.Path = name + fe.Path
+	default:
+		fe.Path = name + "." + fe.Path
+	}
+
+	return fe
+}
+
+// shortError returns error of reading n bytes when only have bytes are available at offset.
+func shortError(offset, n, have int) error {
+	return &FieldError{Offset: offset, Need: n, Have: have, Err: io.ErrUnexpectedEOF}
+}
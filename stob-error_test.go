@@ -0,0 +1,190 @@
+package stob
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+type ErrorFrame struct {
+	Dst        net.HardwareAddr `num:"6"`
+	IPv4Header ErrorHeader
+	Count      byte
+	Items      []ErrorItem `count:"Count"`
+}
+
+type ErrorHeader struct {
+	Version byte
+	Length  uint16 `bo:"be"`
+}
+
+type ErrorItem struct {
+	Name string `prefix:"u8"`
+	Val  int32
+}
+
+func TestFieldError(t *testing.T) {
+	data := []byte{1, 2, 3, 4, 5, 6, 0x04, 0x00}
+
+	var a ErrorFrame
+	err := Unmarshal(data, &a)
+
+	var fe *FieldError
+	if !errors.As(err, &fe) {
+		t.Fatalf("error is not FieldError: %v", err)
+	}
+	if fe.Path != "IPv4Header.Length" || fe.Offset != 7 || fe.Need != 2 || fe.Have != 1 {
+		t.Errorf("unexpected error %+v", fe)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("error does not wrap io.ErrUnexpectedEOF: %v", err)
+	}
+	if err.Error() != "stob: field IPv4Header.Length at offset 7: need 2 bytes, have 1: unexpected EOF" {
+		t.Errorf("unexpected message: %s", err)
+	}
+}
+
+func TestFieldErrorSlice(t *testing.T) {
+	a := ErrorFrame{
+		Dst:   make(net.HardwareAddr, 6),
+		Items: []ErrorItem{{Name: "a", Val: 1}, {Name: "bc", Val: 2}},
+	}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cut the middle of name of the second item
+	err = Unmarshal(data[:len(data)-5], &ErrorFrame{})
+
+	var fe *FieldError
+	if !errors.As(err, &fe) {
+		t.Fatalf("error is not FieldError: %v", err)
+	}
+	if fe.Path != "Items[1].Name" || fe.Offset != 17 || fe.Need != 2 || fe.Have != 1 {
+		t.Errorf("unexpected error %+v", fe)
+	}
+}
+
+func TestFieldErrorTruncated(t *testing.T) {
+	a := YourStruct{
+		Struct:    SubStruct{Addr: make(net.HardwareAddr, 6), IP: make(net.IP, 4)},
+		PtrStruct: &SubStruct{Addr: make(net.HardwareAddr, 6), IP: make(net.IP, 4)},
+		Str:       "string",
+		SliceStr:  []string{"a", "b"},
+		Bytes:     make([]byte, 6),
+	}
+
+	for _, x := range []interface{}{&a, &ErrorFrame{Dst: make(net.HardwareAddr, 6), Items: []ErrorItem{{Name: "a"}}}, &RefStruct{Name: "n", Records: []SubCodecStruct{{A: 1}}, Data: []byte{1, 2, 3}}} {
+		data, err := Marshal(x)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < len(data); i++ {
+			s, err := NewStruct(x)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// handlers must return error instead of panic
+			if _, err := s.c.write(&buffer{p: data[:i]}, s.rv); err != nil {
+				var fe *FieldError
+				if !errors.As(err, &fe) || fe.Path == "" {
+					t.Errorf("%T cut to %d bytes: unexpected error %v", x, i, err)
+				}
+			}
+
+			_, err = s.c.write(&buffer{r: bytes.NewReader(data[:i])}, s.rv)
+			if err != nil {
+				var fe *FieldError
+				if !errors.As(err, &fe) || fe.Path == "" {
+					t.Errorf("%T streamed %d bytes: unexpected error %v", x, i, err)
+				}
+			}
+		}
+	}
+}
+
+func TestFieldErrorLargeLength(t *testing.T) {
+	type large struct {
+		Data []byte `prefix:"u64,be"`
+	}
+
+	data := []byte{0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 1, 2, 3}
+
+	err := NewDecoder(bytes.NewReader(data)).Decode(&large{})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if err := Unmarshal(data, &large{}); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestFieldErrorLargeCount(t *testing.T) {
+	type names struct {
+		Names []string `prefix:"u32"`
+	}
+
+	for _, data := range [][]byte{
+		{0x00, 0x00, 0x10, 0x00},
+		{0xff, 0xff, 0xff, 0xff, 'a', 0},
+	} {
+		if err := Unmarshal(data, &names{}); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("% 02x: unexpected error %v", data, err)
+		}
+
+		err := NewDecoder(bytes.NewReader(data)).Decode(&names{})
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("% 02x: unexpected error of decoder %v", data, err)
+		}
+	}
+
+	// string is not decoded from empty data
+	var a struct {
+		Names []string `num:"2"`
+	}
+	if err := Unmarshal([]byte{'a', 0}, &a); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestFieldErrorEncode(t *testing.T) {
+	type nested struct {
+		A     byte
+		Inner UnionEnvelope
+	}
+
+	_, err := Marshal(&nested{})
+
+	var fe *FieldError
+	if !errors.As(err, &fe) {
+		t.Fatalf("error is not FieldError: %v", err)
+	}
+	if fe.Path != "Inner.Payload" || !strings.Contains(err.Error(), "variant is nil") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestFieldErrorRead(t *testing.T) {
+	s, err := NewStruct(&ErrorHeader{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.Read(make([]byte, 2))
+
+	var fe *FieldError
+	if !errors.As(err, &fe) || fe.Need != 3 || fe.Have != 2 || n != 2 {
+		t.Errorf("unexpected error %v", err)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("error does not wrap io.ErrUnexpectedEOF: %v", err)
+	}
+}
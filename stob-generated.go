@@ -0,0 +1,33 @@
+package stob
+
+import "io"
+
+// Marshaler is implemented by types with code generated by cmd/stobgen, Marshal prefers it to reflection.
+type Marshaler interface {
+	// MarshalStob appends encoded value to b.
+	MarshalStob(b []byte) ([]byte, error)
+
+	// StobSize returns length of encoded value in bytes.
+	StobSize() int
+}
+
+// Unmarshaler is implemented by types with code generated by cmd/stobgen, Unmarshal prefers it to reflection.
+type Unmarshaler interface {
+	// UnmarshalStob decodes value from p, returns count of decoded bytes.
+	UnmarshalStob(p []byte) (int, error)
+}
+
+// ShortError returns error of field name which needs n bytes at offset, but only have bytes are available. It is used by generated code.
+func ShortError(name string, offset, n, have int) error {
+	return &FieldError{Path: name, Offset: offset, Need: n, Have: have, Err: io.ErrUnexpectedEOF}
+}
+
+// WrapFieldError returns err of nested field name as FieldError with name prepended to its path, offset of nested data is added to offset of error. It is used by generated code.
+func WrapFieldError(err error, name string, offset int) error {
+	if fe, ok := err.(*FieldError); ok && fe.Offset >= 0 {
+		fe.Offset += offset
+		offset = fe.Offset
+	}
+
+	return fieldError(err, name, offset)
+}
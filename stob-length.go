@@ -0,0 +1,291 @@
+package stob
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// lengthPrefix is the length written before string or slice, tag `prefix:"u16,be"`, or variable-length one `prefix:"uvarint"`.
+type lengthPrefix struct {
+	size int
+	e    ByteOrder
+
+	// enc is variable-length encoding of prefix, size is 0 then
+	enc varEnc
+}
+
+// parsePrefix parses value of prefix tag: type of length u8, u16, u32 or u64 and optional byte order.
+func parsePrefix(tag string, e ByteOrder) (*lengthPrefix, error) {
+	opts := strings.Split(tag, ",")
+
+	lp := &lengthPrefix{e: e}
+
+	switch opts[0] {
+	case "u8":
+		lp.size = 1
+	case "u16":
+		lp.size = 2
+	case "u32":
+		lp.size = 4
+	case "u64":
+		lp.size = 8
+	default:
+		enc, err := parseVarEnc(opts[0])
+		if err != nil {
+			return nil, fmt.Errorf("unknown prefix type %q", opts[0])
+		}
+		if len(opts) > 1 {
+			return nil, fmt.Errorf("invalid prefix %q, variable-length prefix does not have byte order", tag)
+		}
+		lp.enc = enc
+	}
+
+	if len(opts) > 1 {
+		lp.e = ByteOrder(opts[1])
+		if lp.e != LittleEndian && lp.e != BigEndian {
+			return nil, fmt.Errorf("unknown byte order %q of prefix", opts[1])
+		}
+	}
+
+	if len(opts) > 2 {
+		return nil, fmt.Errorf("invalid prefix %q", tag)
+	}
+
+	return lp, nil
+}
+
+// put appends length n to b.
+func (lp *lengthPrefix) put(b []byte, n int) ([]byte, error) {
+	if lp.enc != 0 {
+		return lp.enc.put(b, uint64(n))
+	}
+
+	b, p := extend(b, lp.size)
+	return b, lp.set(p, n)
+}
+
+// set stores length n to p.
+func (lp *lengthPrefix) set(p []byte, n int) error {
+	if lp.size < 8 && uint64(n) >= 1<<(uint(lp.size)*8) {
+		return fmt.Errorf("length %d overflows %d bytes prefix", n, lp.size)
+	}
+
+	Itob(p, int64(n), lp.e)
+	return nil
+}
+
+// get reads length from buffer.
+func (lp *lengthPrefix) get(buf *buffer) (int, error) {
+	var n uint64
+	if lp.enc != 0 {
+		x, err := lp.enc.get(buf)
+		if err != nil {
+			return 0, err
+		}
+		if lp.enc.signed() && int64(x) < 0 {
+			return 0, fmt.Errorf("negative length %d", int64(x))
+		}
+		n = x
+	} else {
+		p, err := buf.next(lp.size)
+		if err != nil {
+			return 0, err
+		}
+		n = uint64(Btoi(p, lp.e))
+	}
+
+	if n > uint64(maxInt) {
+		return 0, fmt.Errorf("length %d overflows int", n)
+	}
+
+	return int(n), nil
+}
+
+// len returns length of encoded prefix of length n.
+func (lp *lengthPrefix) len(n int) int {
+	if lp.enc != 0 {
+		return lp.enc.len(uint64(n))
+	}
+	return lp.size
+}
+
+const maxInt = int(^uint(0) >> 1)
+
+// lengthRef is the reference to the preceding field that holds length of field, tags `len:"Length"` or `count:"Count"`.
+// Value of referenced field can be adjusted by constant: `len:"Length-20"`.
+type lengthRef struct {
+	name  string
+	index []int
+	adj   int
+
+	// bytes is true if referenced field holds length in bytes, otherwise it holds count of elements
+	bytes bool
+}
+
+// parseRef parses value of len or count tag.
+func parseRef(tag string, bytes bool) (*lengthRef, error) {
+	ref := &lengthRef{name: tag, bytes: bytes}
+
+	if i := strings.IndexAny(tag, "+-"); i > 0 {
+		adj, err := strconv.Atoi(tag[i:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid length reference %q", tag)
+		}
+
+		ref.name = strings.TrimSpace(tag[:i])
+		ref.adj = adj
+	}
+
+	return ref, nil
+}
+
+// resolve looks up referenced field in struct type rt, it should be integer field placed before field f.
+func (ref *lengthRef) resolve(rt reflect.Type, f *field) error {
+	sf, err := lookupIntField(rt, f, ref.name)
+	if err != nil {
+		return err
+	}
+
+	ref.index = sf.Index
+	return nil
+}
+
+// lookupIntField looks up integer field by name in struct type rt, it should be placed before field f.
+func lookupIntField(rt reflect.Type, f *field, name string) (sf reflect.StructField, err error) {
+	sf, ok := rt.FieldByName(name)
+	if !ok {
+		return sf, fmt.Errorf("stob: field %s refers to unknown field %s", f.rsf.Name, name)
+	}
+
+	if sf.Index[0] >= f.index {
+		return sf, fmt.Errorf("stob: field %s refers to field %s which is not placed before it", f.rsf.Name, name)
+	}
+
+	switch sf.Type.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return sf, fmt.Errorf("stob: field %s refers to field %s of non-integer type %s", f.rsf.Name, name, sf.Type)
+	}
+
+	return sf, nil
+}
+
+// get returns length from referenced field of struct sv.
+func (ref *lengthRef) get(sv reflect.Value) (int, error) {
+	rv, err := fieldByIndex(sv, ref.index)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	if rv.CanInt() {
+		n = rv.Int()
+	} else {
+		if rv.Uint() > uint64(maxInt) {
+			return 0, fmt.Errorf("length %d of field %s overflows int", rv.Uint(), ref.name)
+		}
+		n = int64(rv.Uint())
+	}
+
+	n += int64(ref.adj)
+	if n < 0 || n > int64(maxInt) {
+		return 0, fmt.Errorf("invalid length %d taken from field %s", n, ref.name)
+	}
+
+	return int(n), nil
+}
+
+// set stores length n to referenced field of struct sv.
+func (ref *lengthRef) set(sv reflect.Value, n int) error {
+	rv, err := allocByIndex(sv, ref.index)
+	if err != nil {
+		return err
+	}
+
+	x := int64(n) - int64(ref.adj)
+	if err := setInt(rv, x); err != nil {
+		return fmt.Errorf("length %d overflows field %s", x, ref.name)
+	}
+
+	return nil
+}
+
+// sized reports whether length of field is stored outside of it, in prefix or in another field.
+func (f *field) sized() bool {
+	return f.prefix != nil || f.ref != nil
+}
+
+// length returns length of field of struct sv for decoding, or -1 if it is not defined.
+func (f *field) length(buf *buffer, sv reflect.Value) (int, error) {
+	switch {
+	case f.prefix != nil:
+		return f.prefix.get(buf)
+	case f.ref != nil:
+		return f.ref.get(sv)
+	}
+
+	return -1, nil
+}
+
+// rawBytes reports whether field is string or byte slice, its length in bytes is length of value.
+func (f *field) rawBytes() bool {
+	return f.rk == reflect.String || f.rk == reflect.Slice && f.rt.Elem().Kind() == reflect.Uint8
+}
+
+// byteLen returns length of encoded field in bytes.
+func (f *field) byteLen(fv reflect.Value) (int, error) {
+	if f.rawBytes() {
+		return fv.Len(), nil
+	}
+
+	return f.valueSize(fv)
+}
+
+// withRefs returns copy of struct rv with actual lengths and discriminators in the referenced fields. Struct rv is not changed, so one value can be encoded concurrently.
+func (c *Codec) withRefs(rv reflect.Value) (reflect.Value, error) {
+	sv := reflect.New(rv.Type()).Elem()
+	sv.Set(rv)
+
+	return sv, c.fillRefs(sv)
+}
+
+// fillRefs stores actual lengths of fields and discriminators of variants to the referenced fields of struct sv, it should be a copy.
+func (c *Codec) fillRefs(sv reflect.Value) error {
+	for _, f := range c.fields {
+		if f.ref == nil && f.union == nil || f.cond != nil && !f.cond.eval(sv) {
+			continue
+		}
+
+		fv := f.value(sv)
+
+		if f.union != nil {
+			if err := f.union.set(sv, fv); err != nil {
+				return fieldError(err, f.rsf.Name, -1)
+			}
+		}
+
+		if f.ref == nil {
+			continue
+		}
+
+		var n int
+		var err error
+		if f.ref.bytes {
+			n, err = f.byteLen(fv)
+		} else {
+			n = fv.Len()
+		}
+		if err != nil {
+			return fieldError(err, f.rsf.Name, -1)
+		}
+
+		if err := f.ref.set(sv, n); err != nil {
+			return fieldError(err, f.rsf.Name, -1)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,328 @@
+package stob
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type PrefixStruct struct {
+	Name    string           `prefix:"u8"`
+	Data    []byte           `prefix:"u16,be"`
+	Names   []string         `prefix:"u16" size:"4"`
+	Records []SubCodecStruct `prefix:"u32,be"`
+	Last    byte
+}
+
+func TestPrefix(t *testing.T) {
+	a := PrefixStruct{
+		Name:    "name",
+		Data:    []byte{1, 2, 3},
+		Names:   []string{"ab", "cd"},
+		Records: []SubCodecStruct{{A: 1, B: 2}, {A: 3, B: 4}},
+		Last:    0xff,
+	}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []byte{
+		0x04, 'n', 'a', 'm', 'e',
+		0x00, 0x03, 1, 2, 3,
+		0x02, 0x00, 'a', 'b', 0, 0, 'c', 'd', 0, 0,
+		0x00, 0x00, 0x00, 0x02, 0x01, 0x00, 0x02, 0x03, 0x00, 0x04,
+		0xff,
+	}
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	var b PrefixStruct
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", a, b)
+	}
+
+	var c PrefixStruct
+	if err := NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, c) {
+		t.Errorf("stream decoded struct is not equal\n%+v\n%+v", a, c)
+	}
+}
+
+func TestPrefixEmpty(t *testing.T) {
+	var a PrefixStruct
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != 1+2+2+4+1 {
+		t.Errorf("unexpected data % 02x", data)
+	}
+
+	var b PrefixStruct
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Name != "" || len(b.Data) != 0 || len(b.Names) != 0 || len(b.Records) != 0 {
+		t.Errorf("unexpected decoded struct %+v", b)
+	}
+}
+
+func TestPrefixOverflow(t *testing.T) {
+	a := PrefixStruct{Name: strings.Repeat("x", 256)}
+
+	if _, err := Marshal(&a); err == nil {
+		t.Error("expected error for length overflowed prefix")
+	}
+}
+
+func TestPrefixTruncated(t *testing.T) {
+	var a PrefixStruct
+	if err := Unmarshal([]byte{0x05, 'n', 'a'}, &a); err == nil {
+		t.Error("expected error for truncated data")
+	}
+}
+
+func TestPrefixTag(t *testing.T) {
+	type badType struct {
+		X []byte `prefix:"u12"`
+	}
+	if _, err := NewStruct(&badType{}); err == nil {
+		t.Error("expected error for unknown prefix type")
+	}
+
+	type badKind struct {
+		X int `prefix:"u8"`
+	}
+	if _, err := NewStruct(&badKind{}); err == nil {
+		t.Error("expected error for prefix on int")
+	}
+}
+
+type RefStruct struct {
+	NameLen  uint8
+	Count    uint16 `bo:"be"`
+	DataLen  int32
+	Name     string           `len:"NameLen"`
+	Records  []SubCodecStruct `count:"Count"`
+	Data     []byte           `len:"DataLen-2"`
+	Trailing byte
+}
+
+func TestRef(t *testing.T) {
+	a := RefStruct{
+		Name:     "name",
+		Records:  []SubCodecStruct{{A: 1, B: 2}, {A: 3, B: 4}, {A: 5, B: 6}},
+		Data:     []byte{1, 2, 3},
+		Trailing: 0xff,
+	}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// referenced fields are filled in encoded bytes, struct is not changed
+	if a.NameLen != 0 || a.Count != 0 || a.DataLen != 0 {
+		t.Errorf("struct is changed by encoding %+v", a)
+	}
+	a.NameLen, a.Count, a.DataLen = 4, 3, 5
+
+	expect := []byte{
+		0x04, 0x00, 0x03, 0x05, 0x00, 0x00, 0x00,
+		'n', 'a', 'm', 'e',
+		0x01, 0x00, 0x02, 0x03, 0x00, 0x04, 0x05, 0x00, 0x06,
+		1, 2, 3,
+		0xff,
+	}
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	var b RefStruct
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", a, b)
+	}
+
+	var c RefStruct
+	if err := NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, c) {
+		t.Errorf("stream decoded struct is not equal\n%+v\n%+v", a, c)
+	}
+}
+
+func TestRefEmpty(t *testing.T) {
+	a := RefStruct{Trailing: 7}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte{0, 0, 0, 2, 0, 0, 0, 7}) {
+		t.Errorf("unexpected data % 02x", data)
+	}
+
+	var b RefStruct
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Name != "" || len(b.Records) != 0 || len(b.Data) != 0 || b.Trailing != 7 {
+		t.Errorf("unexpected struct %+v", b)
+	}
+
+	// string bounded by length may contain zero bytes
+	data = []byte{3, 0, 0, 2, 0, 0, 0, 'a', 0, 'b', 7}
+	if err := Unmarshal(data, &b); err != nil || b.Name != "a\x00b" || b.Trailing != 7 {
+		t.Errorf("unexpected struct %+v, %v", b, err)
+	}
+}
+
+func TestRefByValue(t *testing.T) {
+	a := RefStruct{Name: "abc"}
+
+	c, err := CodecFor[RefStruct]()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := c.Codec().Encode(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 3 {
+		t.Errorf("length is not filled, % 02x", data)
+	}
+}
+
+type RefBytesStruct struct {
+	Size      uint16
+	NamesSize uint8
+	Records   []SubCodecStruct `len:"Size"`
+	Names     []string         `len:"NamesSize"`
+}
+
+func TestRefBytes(t *testing.T) {
+	a := RefBytesStruct{
+		Records: []SubCodecStruct{{A: 1, B: 2}, {A: 3, B: 4}},
+		Names:   []string{"a", "bc", "d"},
+	}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 6 || data[2] != 7 {
+		t.Errorf("unexpected sizes % 02x", data)
+	}
+
+	// last string without terminating zero is bounded by length
+	data[2] = 6
+	data = data[:len(data)-1]
+
+	var b RefBytesStruct
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a.Records, b.Records) {
+		t.Errorf("decoded records are not equal\n%+v\n%+v", a.Records, b.Records)
+	}
+	if !reflect.DeepEqual(b.Names, []string{"a", "bc", "d"}) {
+		t.Errorf("unexpected names %q", b.Names)
+	}
+}
+
+func TestRefOverflow(t *testing.T) {
+	a := RefStruct{Name: strings.Repeat("x", 256)}
+
+	if _, err := Marshal(&a); err == nil {
+		t.Error("expected error for length overflowed referenced field")
+	}
+
+	b := RefStruct{Data: []byte{1}}
+	if err := Unmarshal([]byte{0, 0, 0, 1, 0, 0, 0}, &b); err == nil {
+		t.Error("expected error for negative length")
+	}
+}
+
+func TestRefTag(t *testing.T) {
+	type unknown struct {
+		Data []byte `len:"Size"`
+	}
+	if _, err := NewStruct(&unknown{}); err == nil {
+		t.Error("expected error for unknown referenced field")
+	}
+
+	type after struct {
+		Data []byte `len:"Size"`
+		Size int
+	}
+	if _, err := NewStruct(&after{}); err == nil {
+		t.Error("expected error for referenced field placed after")
+	}
+
+	type nonInt struct {
+		Size string
+		Data []byte `len:"Size"`
+	}
+	if _, err := NewStruct(&nonInt{}); err == nil {
+		t.Error("expected error for non-integer referenced field")
+	}
+
+	type both struct {
+		Size int
+		Data []byte `len:"Size" prefix:"u8"`
+	}
+	if _, err := NewStruct(&both{}); err == nil {
+		t.Error("expected error for prefix and len tags")
+	}
+}
+
+// TestRefConcurrent is meaningful under race detector: go test -race
+func TestRefConcurrent(t *testing.T) {
+	type message struct {
+		*EmbeddedHeader
+		Data []byte `len:"Length"`
+	}
+
+	a := RefStruct{Name: "name", Data: []byte{1}}
+	m := message{EmbeddedHeader: &EmbeddedHeader{Type: 1}, Data: []byte{2, 3}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := Marshal(&a); err != nil {
+				t.Error(err)
+			}
+			if _, err := SizeOf(&m); err != nil {
+				t.Error(err)
+			}
+			if _, err := Marshal(&m); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if a.NameLen != 0 || m.Length != 0 {
+		t.Errorf("struct is changed by encoding %+v %+v", a, m.EmbeddedHeader)
+	}
+}
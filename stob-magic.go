@@ -0,0 +1,138 @@
+package stob
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// initMagic encodes constant value of field, it is called after sizes of field are known. Integer fields take const tag, strings and byte arrays and slices take magic tag.
+func (f *field) initMagic(tag reflect.StructTag) error {
+	c, isConst := tag.Lookup("const")
+	m, isMagic := tag.Lookup("magic")
+	if !isConst && !isMagic {
+		return nil
+	}
+
+	if isConst && isMagic {
+		return fmt.Errorf("stob: field %s has both const and magic tags", f.rsf.Name)
+	}
+	if f.sized() || f.enc != 0 || f.bits != 0 || f.sum != nil {
+		return fmt.Errorf("stob: constant field %s can not have length, enc, bits or checksum tags", f.rsf.Name)
+	}
+
+	// constant is encoded as is
+	f.tc, f.binary = nil, false
+
+	v := reflect.New(f.rt).Elem()
+
+	if isConst {
+		switch f.rk {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return fmt.Errorf("stob: const tag of field %s is allowed only for integers", f.rsf.Name)
+		}
+
+		if err := setConst(v, c); err != nil {
+			return fmt.Errorf("stob: field %s: %s", f.rsf.Name, err)
+		}
+
+		var x int64
+		if v.CanInt() {
+			x = v.Int()
+		} else {
+			x = int64(v.Uint())
+		}
+
+		f.magic = make([]byte, f.size)
+		Itob(f.magic, x, f.e)
+
+		if f.size < 8 && SignExtend(Btoi(f.magic, f.e), f.size) != x && Btoi(f.magic, f.e) != x {
+			return fmt.Errorf("stob: const %s of field %s does not fit %d bytes", c, f.rsf.Name, f.size)
+		}
+
+		f.magicValue = v
+		return nil
+	}
+
+	switch {
+	case f.rk == reflect.String:
+		v.SetString(m)
+	case f.rk == reflect.Slice && f.rt.Elem().Kind() == reflect.Uint8:
+		v.SetBytes([]byte(m))
+	case f.rk == reflect.Array && f.rt.Elem().Kind() == reflect.Uint8:
+		if len(m) != f.rt.Len() {
+			return fmt.Errorf("stob: magic %q of field %s does not match length of %s", m, f.rsf.Name, f.rt)
+		}
+		reflect.Copy(v, reflect.ValueOf([]byte(m)))
+	default:
+		return fmt.Errorf("stob: magic tag of field %s is allowed only for strings and bytes", f.rsf.Name)
+	}
+
+	if f.rk == reflect.String && f.size != 0 && f.size != len(m) {
+		return fmt.Errorf("stob: magic %q of field %s does not match size %d", m, f.rsf.Name, f.size)
+	}
+
+	f.magic = []byte(m)
+	f.magicValue = v
+	f.len = len(m)
+
+	return nil
+}
+
+// setConst parses integer constant s to v.
+func setConst(v reflect.Value, s string) error {
+	if v.CanInt() {
+		x, err := strconv.ParseInt(s, 0, 64)
+		if err != nil {
+			return fmt.Errorf("invalid const tag %q", s)
+		}
+		return setInt(v, x)
+	}
+
+	x, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return fmt.Errorf("invalid const tag %q", s)
+	}
+	if v.OverflowUint(x) {
+		return fmt.Errorf("value %d overflows %s", x, v.Type())
+	}
+	v.SetUint(x)
+
+	return nil
+}
+
+// Magic encodes constant of field regardless of its value.
+func (f *field) Magic(b []byte, rv reflect.Value) ([]byte, error) {
+	return append(b, f.magic...), nil
+}
+
+// SetMagic checks that decoded bytes are the constant of field and sets it to exported field.
+func (f *field) SetMagic(buf *buffer, rv reflect.Value, n int) error {
+	p, err := buf.next(len(f.magic))
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(p, f.magic) {
+		switch f.rk {
+		case reflect.String, reflect.Array, reflect.Slice:
+			return fmt.Errorf("magic %q expected, got %q", f.magic, p)
+		}
+		return fmt.Errorf("const % 02x expected, got % 02x", f.magic, p)
+	}
+
+	if !rv.CanSet() {
+		return nil
+	}
+
+	if f.rk == reflect.Slice {
+		rv.SetBytes(append([]byte(nil), f.magic...))
+		return nil
+	}
+
+	rv.Set(f.magicValue)
+	return nil
+}
@@ -0,0 +1,104 @@
+package stob
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type MagicPNG struct {
+	Magic  [8]byte `magic:"\x89PNG\r\n\x1a\n"`
+	Length uint32  `bo:"be"`
+	Type   string  `magic:"IHDR"`
+	_      uint16  `const:"0xfeff" bo:"be"`
+	Sync   int32   `const:"-2"`
+	Ver    []byte  `magic:"v1"`
+	Data   []byte  `prefix:"u8"`
+}
+
+func TestMagic(t *testing.T) {
+	a := MagicPNG{Length: 13, Data: []byte{1, 2}}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR\xfe\xff\xfe\xff\xff\xffv1\x02\x01\x02")
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	if n, err := SizeOf(&a); err != nil || n != len(data) {
+		t.Errorf("SizeOf returns %d, %v, expected %d", n, err, len(data))
+	}
+
+	var b MagicPNG
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b.Magic[:]) != "\x89PNG\r\n\x1a\n" || b.Type != "IHDR" || b.Sync != -2 || string(b.Ver) != "v1" || b.Length != 13 {
+		t.Errorf("unexpected struct %+v", b)
+	}
+
+	for _, test := range []struct {
+		i    int
+		path string
+		err  string
+	}{
+		{1, "Magic", `magic "\x89PNG\r\n\x1a\n" expected, got "\x89QNG\r\n\x1a\n"`},
+		{13, "Type", `magic "IHDR" expected, got "IIDR"`},
+		{16, "_", "const fe ff expected, got ff ff"},
+	} {
+		corrupt := append([]byte(nil), data...)
+		corrupt[test.i]++
+
+		err := Unmarshal(corrupt, &b)
+		if fe, ok := err.(*FieldError); !ok || fe.Path != test.path || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected error of %s %q, got %v", test.path, test.err, err)
+		}
+	}
+}
+
+func TestMagicErrors(t *testing.T) {
+	for _, test := range []struct {
+		x   interface{}
+		err string
+	}{
+		{&struct {
+			A [3]byte `magic:"PNG!"`
+		}{}, "does not match length"},
+		{&struct {
+			A string `magic:"PNG" size:"8"`
+		}{}, "does not match size 8"},
+		{&struct {
+			A float32 `magic:"PNG"`
+		}{}, "allowed only for strings and bytes"},
+		{&struct {
+			A string `const:"1"`
+		}{}, "allowed only for integers"},
+		{&struct {
+			A uint8 `const:"0x100"`
+		}{}, "overflows uint8"},
+		{&struct {
+			A int32 `const:"x"`
+		}{}, `invalid const tag "x"`},
+		{&struct {
+			A uint16 `const:"0x1ffff" size:"2"`
+		}{}, "overflows uint16"},
+		{&struct {
+			A uint32 `const:"0x1ffff" size:"2"`
+		}{}, "does not fit 2 bytes"},
+		{&struct {
+			A string `magic:"PNG" prefix:"u8"`
+		}{}, "can not have length"},
+		{&struct {
+			A uint8 `magic:"P" const:"1"`
+		}{}, "both const and magic"},
+	} {
+		_, err := NewStruct(test.x)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%T: expected error %q, got %v", test.x, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package stob
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// position places field at offset from the start of the top-level struct, tag `offset:"0x40"`, or at offset stored in integer field placed before it, tag `at:"SectionOffset"`.
+// Offsets of fields of nested and embedded structs are absolute too, so structs with such fields can not be elements of slices or variants. Offset pointing back to encoded bytes is decoded, but it is rejected on encoding, since bytes are appended in order of fields.
+type position struct {
+	off int
+	ref *lengthRef
+}
+
+// parsePosition parses offset and at tags of field.
+func (f *field) parsePosition(tag reflect.StructTag) error {
+	offset, at := tag.Get("offset"), tag.Get("at")
+	if offset == "" && at == "" {
+		return nil
+	}
+
+	if offset != "" && at != "" {
+		return fmt.Errorf("stob: field %s has both offset and at tags", f.rsf.Name)
+	}
+
+	if f.bits != 0 {
+		return fmt.Errorf("stob: bit field %s can not have offset", f.rsf.Name)
+	}
+
+	if at != "" {
+		ref, err := parseRef(at, true)
+		if err != nil {
+			return fmt.Errorf("stob: field %s: %s", f.rsf.Name, err)
+		}
+
+		f.pos = &position{ref: ref}
+		return nil
+	}
+
+	n, err := strconv.ParseInt(offset, 0, 0)
+	if err != nil || n < 0 {
+		return fmt.Errorf("stob: field %s: invalid offset tag %q", f.rsf.Name, offset)
+	}
+
+	f.pos = &position{off: int(n)}
+	return nil
+}
+
+// get returns offset of field in struct sv.
+func (pos *position) get(sv reflect.Value) (int, error) {
+	if pos.ref != nil {
+		return pos.ref.get(sv)
+	}
+	return pos.off, nil
+}
+
+// gap returns count of zero bytes placed before field of struct sv to move it from offset off to its position, including padding. Base is offset of struct from the start of the top-level struct.
+func (f *field) gap(sv reflect.Value, base, off int) (int, error) {
+	if f.pos == nil {
+		return f.padding(off), nil
+	}
+
+	at, err := f.pos.get(sv)
+	if err != nil {
+		return 0, err
+	}
+
+	if at < base+off {
+		return 0, fmt.Errorf("offset %d overlaps previous fields, which end at %d", at, base+off)
+	}
+
+	return at - base - off + f.padding(at-base), nil
+}
+
+// seek moves to position of field of struct sv, start is offset of struct in the whole decoded data, off is offset of field in struct, origin is offset of the top-level struct.
+func (f *field) seek(buf *buffer, sv reflect.Value, start, off, origin int) error {
+	if f.pos != nil {
+		at, err := f.pos.get(sv)
+		if err != nil {
+			return err
+		}
+
+		if err := buf.seek(origin + at); err != nil {
+			return err
+		}
+		off = origin + at - start
+	}
+
+	// padding is skipped
+	_, err := buf.next(f.padding(off))
+	return err
+}
+
+// nested reports whether field is single struct or pointer to struct encoded by its codec.
+func (f *field) nested() bool {
+	return f.s != nil && f.rk != reflect.Slice && f.rk != reflect.Array && f.union == nil && !f.opaque()
+}
+
+// nestedValue returns struct of nested field rv for encoding, nil pointer is encoded as zero struct.
+func (f *field) nestedValue(rv reflect.Value) reflect.Value {
+	if rv.Kind() != reflect.Ptr {
+		return rv
+	}
+	if rv.IsNil() {
+		return reflect.New(f.s.rt).Elem()
+	}
+	return rv.Elem()
+}
+
+// seek moves to offset off of the whole decoded data. Stream is read up to the offset, io.ReaderAt is read from it.
+func (buf *buffer) seek(off int) error {
+	switch {
+	case off >= buf.base && off <= buf.base+len(buf.p):
+
+	case buf.ra != nil:
+		// previous bytes may be referenced by decoded values, so they are not reused
+		buf.p, buf.base, buf.eof = nil, off, false
+		buf.r = io.NewSectionReader(buf.ra, int64(off), math.MaxInt64-int64(off))
+
+	case off < buf.base:
+		return fmt.Errorf("offset %d is before the start of data %d", off, buf.base)
+
+	default:
+		buf.off = len(buf.p)
+		if _, err := buf.next(off - buf.offset()); err != nil {
+			return err
+		}
+	}
+
+	buf.off = off - buf.base
+	return nil
+}
+
+//
+// io.ReaderAt
+
+// UnmarshalReaderAt decodes struct x from r, only the bytes of fields are read, fields with offset or at tags are read from their offsets.
+func UnmarshalReaderAt(r io.ReaderAt, x interface{}) error {
+	return defaultOptions.UnmarshalReaderAt(r, x)
+}
+
+// UnmarshalReaderAt decodes struct x from r with options o.
+func (o *Options) UnmarshalReaderAt(r io.ReaderAt, x interface{}) error {
+	s, err := o.NewStruct(x)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.c.write(&buffer{r: io.NewSectionReader(r, 0, math.MaxInt64), ra: r}, s.rv)
+	return err
+}
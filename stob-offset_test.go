@@ -0,0 +1,244 @@
+package stob
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type OffsetFile struct {
+	Magic   [4]byte
+	Table   uint32
+	Name    string   `offset:"0x10" size:"4"`
+	Entries []uint16 `at:"Table" num:"2"`
+}
+
+func TestOffset(t *testing.T) {
+	a := OffsetFile{
+		Magic:   [4]byte{0x7f, 'E', 'L', 'F'},
+		Table:   0x20,
+		Name:    "main",
+		Entries: []uint16{1, 2},
+	}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := make([]byte, 0x24)
+	copy(expect, []byte{0x7f, 'E', 'L', 'F', 0x20, 0, 0, 0})
+	copy(expect[0x10:], "main")
+	copy(expect[0x20:], []byte{1, 0, 2, 0})
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	if n, err := SizeOf(&a); err != nil || n != len(data) {
+		t.Errorf("SizeOf returns %d, %v, expected %d", n, err, len(data))
+	}
+
+	var b OffsetFile
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", a, b)
+	}
+
+	var c OffsetFile
+	if err := NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, c) {
+		t.Errorf("struct decoded from stream is not equal\n%+v\n%+v", a, c)
+	}
+
+	// section beyond the end of data
+	data[4] = 0x30
+	err = Unmarshal(data, &b)
+	if fe, ok := err.(*FieldError); !ok || fe.Path != "Entries" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+type OffsetSection struct {
+	Data uint8 `offset:"8"`
+}
+
+type OffsetHeader struct {
+	Count uint8
+	Table uint16 `at:"Count"`
+}
+
+func TestOffsetNested(t *testing.T) {
+	type file struct {
+		Magic [4]byte
+		OffsetSection
+		Header *OffsetHeader
+		Tail   uint8 `offset:"0x10"`
+	}
+
+	a := file{
+		Magic:         [4]byte{1, 2, 3, 4},
+		OffsetSection: OffsetSection{Data: 5},
+		Header:        &OffsetHeader{Count: 12, Table: 0x0706},
+		Tail:          8,
+	}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// offsets of nested and embedded structs are taken from the start of data
+	expect := []byte{1, 2, 3, 4, 0, 0, 0, 0, 5, 12, 0, 0, 6, 7, 0, 0, 8}
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	if n, err := SizeOf(&a); err != nil || n != len(expect) {
+		t.Errorf("SizeOf returns %d, %v, expected %d", n, err, len(expect))
+	}
+
+	var b file
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", a, b)
+	}
+
+	var s file
+	if err := NewDecoder(bytes.NewReader(append([]byte{}, data...))).Decode(&s); err != nil || !reflect.DeepEqual(a, s) {
+		t.Errorf("unexpected struct decoded from stream %+v, %v", s, err)
+	}
+
+	// offsets are taken from the start of encoded struct, not of buffer
+	prefixed, err := AppendMarshal([]byte{0xff}, &a)
+	if err != nil || !bytes.Equal(prefixed[1:], expect) {
+		t.Errorf("unexpected data % 02x, %v", prefixed, err)
+	}
+
+	// nested struct placed after its absolute offset
+	c := struct {
+		Magic [10]byte
+		OffsetSection
+	}{}
+	if _, err := Marshal(&c); err == nil || !strings.Contains(err.Error(), "offset 8 overlaps previous fields, which end at 10") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// absolute offsets can not repeat in elements
+	d := struct {
+		Sections []OffsetSection `num:"2"`
+	}{}
+	if _, err := NewStruct(&d); err == nil || !strings.Contains(err.Error(), "absolute offsets can not repeat") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+type OffsetBack struct {
+	Ptr  uint8
+	Tail [2]byte `offset:"6"`
+	Back uint16  `at:"Ptr"`
+}
+
+func TestOffsetBackward(t *testing.T) {
+	data := []byte{2, 0, 0xaa, 0xbb, 0, 0, 1, 2, 0xff}
+
+	var a OffsetBack
+	s, err := NewStruct(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l, err := s.Write(data); err != nil || l != 8 {
+		t.Errorf("decoded %d bytes, %v, expected 8", l, err)
+	}
+	if a != (OffsetBack{Ptr: 2, Tail: [2]byte{1, 2}, Back: 0xbbaa}) {
+		t.Errorf("unexpected struct %+v", a)
+	}
+
+	var b OffsetBack
+	if err := UnmarshalReaderAt(bytes.NewReader(data), &b); err != nil || b != a {
+		t.Errorf("unexpected struct %+v, %v", b, err)
+	}
+
+	// encoding does not go back
+	if _, err := Marshal(&a); err == nil || !strings.Contains(err.Error(), "overlaps previous fields") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+// countReaderAt counts bytes read from it.
+type countReaderAt struct {
+	r *bytes.Reader
+	n int
+}
+
+func (c *countReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n += n
+	return n, err
+}
+
+func TestOffsetReaderAt(t *testing.T) {
+	type file struct {
+		Size uint32
+		Data []byte `offset:"0x100000" len:"Size"`
+	}
+
+	a := file{Data: []byte{1, 2, 3}}
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0x100003 {
+		t.Fatalf("unexpected length of data %d", len(data))
+	}
+	a.Size = 3
+
+	r := &countReaderAt{r: bytes.NewReader(data)}
+
+	var b file
+	if err := UnmarshalReaderAt(r, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", a, b)
+	}
+	if r.n != 7 {
+		t.Errorf("%d bytes are read, the gap should be skipped", r.n)
+	}
+}
+
+func TestOffsetErrors(t *testing.T) {
+	for _, test := range []struct {
+		x   interface{}
+		err string
+	}{
+		{&struct {
+			N uint8
+			A uint8 `offset:"1" at:"N"`
+		}{}, "both offset and at tags"},
+		{&struct {
+			A uint8 `offset:"x"`
+		}{}, `invalid offset tag "x"`},
+		{&struct {
+			A uint8 `bits:"4" offset:"2"`
+		}{}, "can not have offset"},
+		{&struct {
+			A uint32
+			B uint8 `offset:"2"`
+		}{}, "at offset 2 overlaps previous fields, which end at 4"},
+		{&struct {
+			A uint8 `at:"N"`
+		}{}, "refers to unknown field N"},
+	} {
+		_, err := NewStruct(test.x)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%T: expected error %q, got %v", test.x, test.err, err)
+		}
+	}
+}
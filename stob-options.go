@@ -0,0 +1,132 @@
+package stob
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// Options of compiling codecs. Codecs are compiled once per type and cached in options, so options should not be changed after the first use.
+type Options struct {
+	// Lenient turns off validation of tags and types: malformed tags are ignored and unsupported types are copied as raw memory.
+	Lenient bool
+
+	// CheckOverflow makes encoding return error if value of integer does not fit its size, otherwise value is truncated.
+	CheckOverflow bool
+
+	// Align places fields at offsets aligned to their natural alignment and pads structs to multiple of it, as C compiler does.
+	Align bool
+
+	// Unexported makes unexported fields encoded too, they are accessed by unsafe pointers. Single struct turns it on by blank field `_ struct{}` with tag `stob:"unexported"`.
+	Unexported bool
+
+	// CheckReserved makes decoding return error if reserved bytes of blank fields or fields with reserved tag are not zero or fill byte.
+	CheckReserved bool
+
+	// SkipChecksum turns off verification of checksum fields on decoding.
+	SkipChecksum bool
+
+	// Pack limits alignment of fields as #pragma pack(n) does, it implies Align.
+	Pack int
+
+	// codecs is cache of compiled codecs, map[reflect.Type]*Codec, codecs with inherited byte order are stored by codecKey
+	codecs sync.Map
+
+	// types are codecs registered by RegisterCodec, map[reflect.Type]TypeCodec
+	types sync.Map
+
+	// used are types looked up for registered codecs, map[reflect.Type]bool
+	used sync.Map
+}
+
+// defaultOptions are used by package level functions.
+var defaultOptions = &Options{}
+
+// Lenient options skip validation of tags: stob.Lenient.Marshal(&a)
+var Lenient = &Options{Lenient: true}
+
+// Compile returns codec of struct type rt compiled with options o.
+func (o *Options) Compile(rt reflect.Type) (*Codec, error) {
+	return o.compile(rt, "", nil)
+}
+
+// codecKey is the key of codec which fields inherit byte order e from struct field.
+type codecKey struct {
+	rt reflect.Type
+	e  ByteOrder
+}
+
+// compile returns codec of struct type rt, its fields without bo tag have byte order e, or DefaultEndian if e is empty. Parents are codecs being compiled, they are tracked to reject recursive types.
+func (o *Options) compile(rt reflect.Type, e ByteOrder, parents []codecKey) (*Codec, error) {
+	var key interface{} = rt
+	if e != "" {
+		key = codecKey{rt: rt, e: e}
+	}
+
+	if c, ok := o.codecs.Load(key); ok {
+		return c.(*Codec), nil
+	}
+
+	self := codecKey{rt: rt, e: e}
+	for _, k := range parents {
+		if k == self {
+			return nil, fmt.Errorf("stob: recursive type %s is not supported", rt)
+		}
+	}
+
+	c, err := newCodec(rt, o, e, append(parents[:len(parents):len(parents)], self))
+	if err != nil {
+		return nil, err
+	}
+
+	actual, _ := o.codecs.LoadOrStore(key, c)
+	return actual.(*Codec), nil
+}
+
+func (o *Options) NewStruct(x interface{}) (*Struct, error) {
+	rv := reflect.ValueOf(x)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil, errors.New("stob: NewStruct requires non-nil pointer to struct")
+	}
+
+	c, err := o.Compile(rv.Type().Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Struct{rv: rv.Elem(), c: c}, nil
+}
+
+func (o *Options) Marshal(x interface{}) ([]byte, error) {
+	s, err := o.NewStruct(x)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := s.c.size(s.rv)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.c.read(make([]byte, 0, n), s.rv)
+}
+
+func (o *Options) Unmarshal(data []byte, x interface{}) error {
+	s, err := o.NewStruct(x)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Write(data)
+	return err
+}
+
+func (o *Options) NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, o: o}
+}
+
+func (o *Options) NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, o: o}
+}
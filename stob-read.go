@@ -1,6 +1,7 @@
 package stob
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"math"
@@ -21,49 +22,124 @@ type Size interface {
 	Size() int
 }
 
+var readerType = reflect.TypeOf((*Reader)(nil)).Elem()
+
 func (s *Struct) Read(p []byte) (n int, err error) {
-	for _, f := range s.fields {
+	b, err := s.c.read(p[:0:len(p)], s.rv)
+	if err != nil {
+		return 0, err
+	}
+
+	n = copy(p, b)
+	if n < len(b) {
+		return n, shortError(0, len(b), len(p))
+	}
 
-		// log.Println(f.rsf.Name, f.len, n, len(p))
+	return n, io.EOF
+}
+
+func (c *Codec) read(b []byte, rv reflect.Value) ([]byte, error) {
+	return c.readAt(b, rv, len(b))
+}
 
-		if f.len+n > len(p) {
-			return n, io.ErrUnexpectedEOF
+// readAt encodes struct rv to b, origin is index of the top-level struct in b, positions of fields are taken from it.
+func (c *Codec) readAt(b []byte, rv reflect.Value, origin int) (_ []byte, err error) {
+	if c.refs {
+		if rv, err = c.withRefs(rv); err != nil {
+			return b, err
 		}
+	} else if c.unexported && !rv.CanAddr() {
+		rv = addr(rv).Elem()
+	}
+
+	start := len(b)
 
-		n += f.Read(p[n:])
+	var spans []span
+	if c.sums {
+		spans = make([]span, len(c.fields))
 	}
 
-	return n, io.EOF
+	for i, f := range c.fields {
+
+		// log.Println(f.rsf.Name, f.len, len(b))
+
+		offset := len(b)
+		if b, err = f.encode(b, rv, offset-start, origin); err != nil {
+			return b, fieldError(err, f.rsf.Name, offset)
+		}
+
+		if spans != nil {
+			spans[i] = f.span(rv, start, offset, len(b))
+		}
+	}
+
+	if spans != nil {
+		c.putSums(b, spans)
+	}
+
+	b, _ = extend(b, c.trailing(len(b)-start))
+
+	return b, nil
 }
 
-type fieldReader func(p []byte) int
+// encode encodes field of struct sv, off is offset of field in struct, origin is index of the top-level struct in b.
+func (f *field) encode(b []byte, sv reflect.Value, off, origin int) (_ []byte, err error) {
+	if f.cond != nil && !f.cond.eval(sv) {
+		return b, nil
+	}
 
-func (f *field) setReader() (err error) {
-	rt := f.rv.Type()
-	if f.rv.Kind() == reflect.Ptr {
-		rt = f.rv.Type().Elem()
+	gap, err := f.gap(sv, len(b)-off-origin, off)
+	if err != nil {
+		return b, err
 	}
+	b, _ = extend(b, gap)
 
-	rv := reflect.New(rt)
+	if f.nested() && f.s.pos {
+		return f.s.readAt(b, f.nestedValue(f.value(sv)), origin)
+	}
 
-	if _, ok := rv.Interface().(Reader); ok {
-		f.rk = f.rv.Kind()
+	if f.group != nil {
+		return f.group.encode(b, sv), nil
+	}
 
-		f.Read = func(p []byte) int {
-			n, err := f.rv.Interface().(Reader).Read(p)
-			if err != nil {
-				return n
-			}
+	fv := f.value(sv)
 
-			return n
+	if f.prefix != nil && !f.opaque() {
+		if b, err = f.prefix.put(b, fv.Len()); err != nil {
+			return b, err
 		}
+	}
+
+	return f.Read(b, fv)
+}
+
+type fieldReader func(b []byte, rv reflect.Value) ([]byte, error)
 
+func (f *field) setReader() (err error) {
+	if f.magic != nil {
+		f.Read = f.Magic
 		return nil
 	}
 
-	// if _, ok := f.rv.Interface().(Reader); ok {
-	// 	return nil
-	// }
+	if f.tc != nil {
+		f.Read = f.Registered
+		return nil
+	}
+
+	if reflect.PtrTo(baseType(f.rt)).Implements(readerType) {
+		f.Read = f.Reader
+		return nil
+	}
+
+	if f.binary {
+		f.Read = f.Binary
+		return nil
+	}
+
+	if f.enc != 0 {
+		f.Read = f.Varint
+		return nil
+	}
 
 	switch f.rk {
 	case reflect.String:
@@ -86,270 +162,406 @@ func (f *field) setReader() (err error) {
 	case reflect.Float64:
 		f.Read = f.Float64
 
-	case reflect.Slice:
+	case reflect.Slice, reflect.Array:
 
-		switch f.rv.Interface().(type) {
-		case []string:
+		switch f.rt.Elem().Kind() {
+		case reflect.String:
 			f.Read = f.SliceString
-		case []int, []int8, []int16, []int32, []int64:
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 			f.Read = f.SliceInt
-		case []uint, []uint16, []uint32, []uint64:
+		case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 			f.Read = f.SliceUint
-		case []byte:
+		case reflect.Uint8:
 			f.Read = f.Bytes
-		case []bool:
+		case reflect.Bool:
 			f.Read = f.SliceBool
-		default:
+		case reflect.Float32:
+			f.Read = f.SliceFloat32
+		case reflect.Float64:
+			f.Read = f.SliceFloat64
+		case reflect.Struct:
+			f.s, err = f.compileElem(f.rt.Elem())
+			f.Read = f.SliceStruct
+		case reflect.Ptr:
 			f.Read = f.Custom
-		}
-
-	case reflect.Array:
-
-		switch f.rv.Index(0).Interface().(type) {
-		case string:
-			f.Read = f.SliceString
-		case int, int8, int16, int32, int64:
-			f.Read = f.SliceInt
-		case uint, uint16, uint32, uint64:
-			f.Read = f.SliceUint
-		case byte:
-			f.Read = f.Bytes
-		case bool:
-			f.Read = f.SliceBool
+			if f.rt.Elem().Elem().Kind() == reflect.Struct {
+				f.s, err = f.compileElem(f.rt.Elem().Elem())
+				f.Read = f.SliceStruct
+			}
 		default:
 			f.Read = f.Custom
 		}
 
 	case reflect.Struct:
-		f.s, err = newStruct(f.rv)
+		f.s, err = f.compile(f.rt)
 		f.Read = f.Struct
 
 	case reflect.Ptr:
-		if f.rv.IsNil() {
-			f.rv.Set(reflect.New(f.rv.Type().Elem()))
+		if f.rt.Elem().Kind() != reflect.Struct {
+			return fmt.Errorf("stob: unsupported pointer type %s of field %s", f.rt, f.rsf.Name)
 		}
 
-		f.s, err = newStruct(f.rv.Elem())
+		f.s, err = f.compile(f.rt.Elem())
 		f.Read = f.Struct
 
+	case reflect.Interface:
+		f.Read = f.Custom
+		if f.union != nil {
+			f.Read = f.Variant
+		}
+
 	default:
 		f.Read = f.Custom
-		// log.Printf("%T\n", f.rv.Interface())
 		// err = fmt.Errorf("Unknown field type, %s:%T", f.rsf.Name, f.rv.Interface())
 	}
 
 	return
 }
 
+// compileElem returns codec of struct type rt of elements of slice or array. Struct without encoded fields is rejected unless options are lenient, since its elements would be lost silently.
+func (f *field) compileElem(rt reflect.Type) (*Codec, error) {
+	c, err := f.compile(rt)
+	if err == nil && len(c.fields) == 0 && !f.o.Lenient {
+		return nil, fmt.Errorf("stob: elements of field %s have type %s without encoded fields", f.rsf.Name, rt)
+	}
+	if err == nil && c.pos {
+		return nil, fmt.Errorf("stob: elements of field %s have type %s with offset fields, absolute offsets can not repeat", f.rsf.Name, rt)
+	}
+	return c, err
+}
+
+// baseType returns type of element if rt is pointer.
+func baseType(rt reflect.Type) reflect.Type {
+	if rt.Kind() == reflect.Ptr {
+		return rt.Elem()
+	}
+	return rt
+}
+
+// compile returns codec of nested struct type rt, its fields inherit byte order of field f.
+func (f *field) compile(rt reflect.Type) (*Codec, error) {
+	return f.o.compile(rt, f.bo, f.parents)
+}
+
+// addr returns pointer to value, nil pointers and not addressable values are replaced by the new ones.
+func addr(rv reflect.Value) reflect.Value {
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return reflect.New(rv.Type().Elem())
+		}
+		return rv
+	}
+
+	if rv.CanAddr() {
+		return rv.Addr()
+	}
+
+	ptr := reflect.New(rv.Type())
+	ptr.Elem().Set(rv)
+	return ptr
+}
+
+// extend appends n zero bytes to b, returns extended slice and appended part of it.
+func extend(b []byte, n int) ([]byte, []byte) {
+	l := len(b)
+	b = append(b, make([]byte, n)...)
+	return b, b[l:]
+}
+
+//
+// custom reader
+
+func (f *field) Reader(b []byte, rv reflect.Value) ([]byte, error) {
+	r := addr(rv).Interface().(Reader)
+
+	b, p := extend(b, r.Size())
+	if _, err := r.Read(p); err != nil && err != io.EOF {
+		return b, err
+	}
+
+	return b, nil
+}
+
 //
 // string
 
-func putString(p []byte, s []byte, l int) int {
+func putString(b []byte, s string, l int) []byte {
 	if l == 0 {
-		s = append(s, 0x00)
-		l = len(s)
-	} else if len(s) < l {
-		s = append(s, make([]byte, l-len(s))...)
+		b = append(b, s...)
+		return append(b, 0x00)
 	}
 
-	for i := 0; i < l; i++ {
-		p[i] = s[i]
-	}
+	b, p := extend(b, l)
+	copy(p, s)
 
-	return l
+	return b
 }
 
-func (f *field) String(p []byte) int {
-	return putString(p, []byte(f.rv.String()), f.size)
+func (f *field) String(b []byte, rv reflect.Value) ([]byte, error) {
+	if f.sized() {
+		return append(b, rv.String()...), nil
+	}
+
+	return putString(b, rv.String(), f.size), nil
 }
 
-func (f *field) SliceString(p []byte) (n int) {
+func (f *field) SliceString(b []byte, rv reflect.Value) ([]byte, error) {
 	count := f.num
 	if count == 0 {
-		count = f.rv.Len()
+		count = rv.Len()
 	}
 
 	for i := 0; i < count; i++ {
-		if i < f.rv.Len() {
-			n += putString(p[n:], []byte(f.rv.Index(i).String()), f.size)
+		if i < rv.Len() {
+			b = putString(b, rv.Index(i).String(), f.size)
 		} else {
-			n += putString(p[n:n+f.size], nil, f.size)
+			b = putString(b, "", f.size)
 		}
 	}
-	return n
+	return b, nil
 }
 
 //
 // int
 
-func (f *field) Int(p []byte) int {
-	Itob(p[:f.size], f.rv.Int(), f.e)
-	return f.size
+func (f *field) Int(b []byte, rv reflect.Value) ([]byte, error) {
+	x := rv.Int()
+	if f.o.CheckOverflow && !fitsInt(x, f.size) {
+		return b, fmt.Errorf("value %d overflows %d bytes", x, f.size)
+	}
+
+	b, p := extend(b, f.size)
+	Itob(p, x, f.e)
+	return b, nil
 }
 
-func (f *field) SliceInt(p []byte) (n int) {
+func (f *field) SliceInt(b []byte, rv reflect.Value) ([]byte, error) {
 	count := f.num
 	if count == 0 {
-		count = f.rv.Len()
+		count = rv.Len()
 	}
 
-	for i := 0; i < count; i++ {
-		Itob(p[n:n+f.size], f.rv.Index(i).Int(), f.e)
-		n += f.size
+	b, p := extend(b, count*f.size)
+	for i := 0; i < count && i < rv.Len(); i++ {
+		x := rv.Index(i).Int()
+		if f.o.CheckOverflow && !fitsInt(x, f.size) {
+			return b, fmt.Errorf("value %d of element %d overflows %d bytes", x, i, f.size)
+		}
+
+		Itob(p[i*f.size:(i+1)*f.size], x, f.e)
 	}
 
-	return n
+	return b, nil
 }
 
 //
 // uint
 
-func (f *field) Uint(p []byte) int {
-	Itob(p[:f.size], int64(f.rv.Uint()), f.e)
-	return f.size
+func (f *field) Uint(b []byte, rv reflect.Value) ([]byte, error) {
+	x := rv.Uint()
+	if f.o.CheckOverflow && !fitsUint(x, f.size) {
+		return b, fmt.Errorf("value %d overflows %d bytes", x, f.size)
+	}
+
+	b, p := extend(b, f.size)
+	Itob(p, int64(x), f.e)
+	return b, nil
 }
 
-func (f *field) SliceUint(p []byte) (n int) {
+func (f *field) SliceUint(b []byte, rv reflect.Value) ([]byte, error) {
 	count := f.num
 	if count == 0 {
-		count = f.rv.Len()
+		count = rv.Len()
 	}
 
-	for i := 0; i < count; i++ {
-		Itob(p[n:n+f.size], int64(f.rv.Index(i).Uint()), f.e)
-		n += f.size
+	b, p := extend(b, count*f.size)
+	for i := 0; i < count && i < rv.Len(); i++ {
+		x := rv.Index(i).Uint()
+		if f.o.CheckOverflow && !fitsUint(x, f.size) {
+			return b, fmt.Errorf("value %d of element %d overflows %d bytes", x, i, f.size)
+		}
+
+		Itob(p[i*f.size:(i+1)*f.size], int64(x), f.e)
 	}
-	return n
+	return b, nil
 }
 
 //
 // byte
 
-func (f *field) Byte(p []byte) int {
-	p[0] = byte(f.rv.Uint())
-	return 1
+func (f *field) Byte(b []byte, rv reflect.Value) ([]byte, error) {
+	return append(b, byte(rv.Uint())), nil
 }
 
-func (f *field) Bytes(p []byte) int {
+func (f *field) Bytes(b []byte, rv reflect.Value) ([]byte, error) {
 	count := f.num
 	if count == 0 {
-		count = f.rv.Len()
+		count = rv.Len()
 	}
 
-	for i := 0; i < count; i++ {
-		p[i] = f.rv.Index(i).Interface().(byte)
+	b, p := extend(b, count)
+	for i := 0; i < count && i < rv.Len(); i++ {
+		p[i] = byte(rv.Index(i).Uint())
 	}
 
-	return count
+	return b, nil
 }
 
 //
 // bool
 
-func (f *field) Bool(p []byte) int {
-	if f.rv.Bool() {
-		p[0] = 0x01
-	} else {
-		p[0] = 0x00
+func (f *field) Bool(b []byte, rv reflect.Value) ([]byte, error) {
+	if rv.Bool() {
+		return append(b, 0x01), nil
 	}
 
-	return 1
+	return append(b, 0x00), nil
 }
 
-func (f *field) SliceBool(p []byte) int {
-	count := f.rv.Len()
+func (f *field) SliceBool(b []byte, rv reflect.Value) ([]byte, error) {
+	count := f.num
+	if count == 0 {
+		count = rv.Len()
+	}
 
-	for i := 0; i < count; i++ {
-		if f.rv.Index(i).Bool() {
+	b, p := extend(b, count)
+	for i := 0; i < count && i < rv.Len(); i++ {
+		if rv.Index(i).Bool() {
 			p[i] = 0x01
-		} else {
-			p[i] = 0x00
 		}
 	}
 
-	return count
+	return b, nil
 }
 
 // float32
 
-func (f *field) Float32(p []byte) int {
-	uf := math.Float32bits(float32(f.rv.Float()))
-	Itob(p[:f.size], int64(uf), f.e)
-	return f.size
+func (f *field) Float32(b []byte, rv reflect.Value) ([]byte, error) {
+	b, p := extend(b, f.size)
+	uf := math.Float32bits(float32(rv.Float()))
+	Itob(p, int64(uf), f.e)
+	return b, nil
 }
 
-func (f *field) SliceFloat32(p []byte) (n int) {
+func (f *field) SliceFloat32(b []byte, rv reflect.Value) ([]byte, error) {
 	count := f.num
 	if count == 0 {
-		count = f.rv.Len()
+		count = rv.Len()
 	}
 
-	for i := 0; i < count; i++ {
-		uf := math.Float32bits(float32(f.rv.Index(i).Float()))
-		Itob(p[n:n+f.size], int64(uf), f.e)
-		n += f.size
+	b, p := extend(b, count*f.size)
+	for i := 0; i < count && i < rv.Len(); i++ {
+		uf := math.Float32bits(float32(rv.Index(i).Float()))
+		Itob(p[i*f.size:(i+1)*f.size], int64(uf), f.e)
 	}
 
-	return
+	return b, nil
 }
 
 //
 // float64
 
-func (f *field) Float64(p []byte) int {
-	uf := math.Float64bits(f.rv.Float())
-	Itob(p[:f.size], int64(uf), f.e)
-	return f.size
+func (f *field) Float64(b []byte, rv reflect.Value) ([]byte, error) {
+	b, p := extend(b, f.size)
+	uf := math.Float64bits(rv.Float())
+	Itob(p, int64(uf), f.e)
+	return b, nil
 }
 
-func (f *field) SliceFloat64(p []byte) (n int) {
+func (f *field) SliceFloat64(b []byte, rv reflect.Value) ([]byte, error) {
 	count := f.num
 	if count == 0 {
-		count = f.rv.Len()
+		count = rv.Len()
 	}
 
-	for i := 0; i < count; i++ {
-		uf := math.Float64bits(f.rv.Float())
-		Itob(p[n:n+f.size], int64(uf), f.e)
-		n += f.size
+	b, p := extend(b, count*f.size)
+	for i := 0; i < count && i < rv.Len(); i++ {
+		uf := math.Float64bits(rv.Index(i).Float())
+		Itob(p[i*f.size:(i+1)*f.size], int64(uf), f.e)
 	}
 
-	return
+	return b, nil
 }
 
 //
 // struct
 
-func (f *field) Struct(p []byte) (n int) {
-	for _, subf := range f.s.fields {
-		n += subf.Read(p[n:])
+func (f *field) Struct(b []byte, rv reflect.Value) ([]byte, error) {
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv = reflect.New(f.s.rt)
+		}
+		rv = rv.Elem()
 	}
 
-	return n
+	return f.s.read(b, rv)
+}
+
+func (f *field) SliceStruct(b []byte, rv reflect.Value) (_ []byte, err error) {
+	count := f.num
+	if count == 0 {
+		count = rv.Len()
+	}
+
+	for i := 0; i < count; i++ {
+		offset := len(b)
+
+		// missing elements and nil pointers are encoded as zero structs
+		ev := reflect.New(f.s.rt).Elem()
+		if i < rv.Len() {
+			ev = reflect.Indirect(rv.Index(i))
+			if !ev.IsValid() {
+				ev = reflect.New(f.s.rt).Elem()
+			}
+		}
+
+		b, err = f.s.read(b, ev)
+		if err != nil {
+			return b, fieldError(err, fmt.Sprintf("[%d]", i), offset)
+		}
+	}
+
+	return b, nil
 }
 
 //
 // custom types
 
-// Custom use unsafe pointer
-func (f *field) Custom(p []byte) int {
+// Custom copy raw memory of value
+func (f *field) Custom(b []byte, rv reflect.Value) ([]byte, error) {
 	count := f.num
-	if count == 0 {
-		count = int(f.rv.Type().Size())
+	if f.rk == reflect.Slice && (f.sized() || count == 0) {
+		count = rv.Len() * int(f.rt.Elem().Size())
+	} else if count == 0 {
+		count = int(f.rt.Size())
 	}
 
-	ptr := f.rv.Pointer()
-	if ptr != 0 {
-		for i := 0; i < count; i++ {
-			p[i] = *((*byte)(unsafe.Pointer(uintptr(ptr) + uintptr(i))))
-		}
-	} else {
-		for i := 0; i < count; i++ {
-			p[i] = 0x00
+	b, p := extend(b, count)
+	copy(p, rawBytes(rv))
+
+	return b, nil
+}
+
+// rawBytes returns memory of value, for slices it is memory of its elements.
+func rawBytes(rv reflect.Value) []byte {
+	if rv.Kind() == reflect.Slice {
+		if rv.Len() == 0 {
+			return nil
 		}
+		return unsafe.Slice((*byte)(rv.UnsafePointer()), rv.Len()*int(rv.Type().Elem().Size()))
 	}
 
-	return count
+	return unsafe.Slice((*byte)(addr(rv).UnsafePointer()), rv.Type().Size())
+}
+
+// fitsInt reports whether signed x fits size bytes.
+func fitsInt(x int64, size int) bool {
+	return size >= 8 || SignExtend(x, size) == x
+}
+
+// fitsUint reports whether unsigned x fits size bytes.
+func fitsUint(x uint64, size int) bool {
+	return size >= 8 || x>>(uint(size)*8) == 0
 }
 
 // Itob convert int to bytes
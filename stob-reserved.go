@@ -0,0 +1,69 @@
+package stob
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// initReserved makes field reserved bytes: blank field `_ [3]byte` or field with tag `reserved:"4"`. Value of field is not encoded, bytes are filled by zeros or by byte of tag `fill:"0xff"`, on decoding they are skipped or checked with CheckReserved options.
+func (f *field) initReserved(tag reflect.StructTag) (err error) {
+	if f.sized() || f.enc != 0 || f.bits != 0 || f.sum != nil || f.magic != nil {
+		return fmt.Errorf("stob: reserved field %s can not have length, enc, bits, checksum or constant tags", f.rsf.Name)
+	}
+
+	if s := tag.Get("fill"); s != "" {
+		x, err := strconv.ParseUint(s, 0, 8)
+		if err != nil {
+			return fmt.Errorf("stob: field %s: invalid fill tag %q", f.rsf.Name, s)
+		}
+		f.fill = byte(x)
+	}
+
+	if f.reserved == 0 {
+		if f.rk == reflect.Struct {
+			if err = f.lookupStructSizes(); err != nil {
+				return err
+			}
+		}
+		f.reserved = f.len
+	}
+
+	if f.reserved == 0 {
+		return fmt.Errorf("stob: length of reserved field %s is unknown, it should have reserved tag", f.rsf.Name)
+	}
+
+	f.len = f.reserved
+	f.tc, f.binary = nil, false
+	f.Read = f.Reserved
+	f.Write = f.SetReserved
+
+	return nil
+}
+
+// Reserved encodes reserved bytes.
+func (f *field) Reserved(b []byte, rv reflect.Value) ([]byte, error) {
+	b, p := extend(b, f.reserved)
+	if f.fill != 0 {
+		for i := range p {
+			p[i] = f.fill
+		}
+	}
+	return b, nil
+}
+
+// SetReserved skips reserved bytes, with CheckReserved options they should be equal to fill byte.
+func (f *field) SetReserved(buf *buffer, rv reflect.Value, n int) error {
+	p, err := buf.next(f.reserved)
+	if err != nil || !f.o.CheckReserved {
+		return err
+	}
+
+	for _, c := range p {
+		if c != f.fill {
+			return fmt.Errorf("reserved bytes % 02x are not filled by %#02x", p, f.fill)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package stob
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type ReservedHeader struct {
+	Type  uint8
+	_     [3]byte
+	Flags uint8 `bits:"3"`
+	_     uint8 `bits:"5"`
+	_     uint16
+	Spare [4]byte `reserved:"2" fill:"0xff"`
+	Len   uint32
+}
+
+func TestReserved(t *testing.T) {
+	a := ReservedHeader{Type: 1, Flags: 5, Spare: [4]byte{1, 2, 3, 4}, Len: 7}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []byte{1, 0, 0, 0, 0xa0, 0, 0, 0xff, 0xff, 7, 0, 0, 0}
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	if n, err := SizeOf(&a); err != nil || n != len(data) {
+		t.Errorf("SizeOf returns %d, %v, expected %d", n, err, len(data))
+	}
+
+	// reserved bytes are ignored
+	data[2], data[4], data[8] = 9, 0xbf, 0
+	var b ReservedHeader
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b != (ReservedHeader{Type: 1, Flags: 5, Len: 7}) {
+		t.Errorf("unexpected struct %+v", b)
+	}
+
+	o := &Options{CheckReserved: true}
+
+	err = o.Unmarshal(data, &b)
+	if fe, ok := err.(*FieldError); !ok || fe.Path != "_" || fe.Offset != 1 || !strings.Contains(err.Error(), "reserved bytes 00 09 00 are not filled by 0x00") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	data[2] = 0
+	err = o.Unmarshal(data, &b)
+	if fe, ok := err.(*FieldError); !ok || fe.Path != "Spare" || !strings.Contains(err.Error(), "are not filled by 0xff") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	data[8] = 0xff
+	if err := o.Unmarshal(data, &b); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestReservedErrors(t *testing.T) {
+	for _, test := range []struct {
+		x   interface{}
+		err string
+	}{
+		{&struct {
+			A uint8
+			_ string
+		}{}, "length of reserved field _ is unknown"},
+		{&struct {
+			A []byte `reserved:"2" prefix:"u8"`
+		}{}, "can not have length"},
+		{&struct {
+			A uint8 `reserved:"x"`
+		}{}, `invalid reserved tag "x"`},
+		{&struct {
+			_ [2]byte `fill:"0x100"`
+		}{}, `invalid fill tag "0x100"`},
+	} {
+		_, err := NewStruct(test.x)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%T: expected error %q, got %v", test.x, test.err, err)
+		}
+	}
+}
+
+func TestReservedZeroSize(t *testing.T) {
+	// blank fields of zero size are common idioms, they are not encoded
+	type nocompare struct {
+		_ [0]func()
+		A uint8
+		_ struct{}
+		B uint16 `bo:"be"`
+	}
+
+	a := nocompare{A: 1, B: 2}
+	data, err := Marshal(&a)
+	if err != nil || !bytes.Equal(data, []byte{1, 0, 2}) {
+		t.Fatalf("unexpected data % 02x, %v", data, err)
+	}
+
+	var b nocompare
+	if err := Unmarshal(data, &b); err != nil || b.A != 1 || b.B != 2 {
+		t.Errorf("unexpected struct %+v, %v", b, err)
+	}
+}
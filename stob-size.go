@@ -0,0 +1,310 @@
+package stob
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// SizeOf returns exact length of encoded struct x, x should be struct or pointer to struct.
+func SizeOf(x interface{}) (int, error) {
+	if m, ok := x.(Marshaler); ok {
+		return m.StobSize(), nil
+	}
+
+	return defaultOptions.SizeOf(x)
+}
+
+// MarshalTo encodes struct x to p, returns count of written bytes. If p is too short, nothing is written and FieldError with required length is returned.
+func MarshalTo(p []byte, x interface{}) (int, error) {
+	return defaultOptions.MarshalTo(p, x)
+}
+
+// AppendMarshal appends encoded struct x to dst.
+func AppendMarshal(dst []byte, x interface{}) ([]byte, error) {
+	if m, ok := x.(Marshaler); ok {
+		return m.MarshalStob(grow(dst, m.StobSize()))
+	}
+
+	return defaultOptions.AppendMarshal(dst, x)
+}
+
+func (o *Options) SizeOf(x interface{}) (int, error) {
+	c, rv, err := o.value(x)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.size(rv)
+}
+
+func (o *Options) MarshalTo(p []byte, x interface{}) (int, error) {
+	c, rv, err := o.value(x)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := c.size(rv)
+	if err != nil {
+		return 0, err
+	}
+	if n > len(p) {
+		return 0, shortError(0, n, len(p))
+	}
+
+	b, err := c.read(p[:0:n], rv)
+	return len(b), err
+}
+
+func (o *Options) AppendMarshal(dst []byte, x interface{}) ([]byte, error) {
+	c, rv, err := o.value(x)
+	if err != nil {
+		return dst, err
+	}
+
+	n, err := c.size(rv)
+	if err != nil {
+		return dst, err
+	}
+
+	return c.read(grow(dst, n), rv)
+}
+
+// value returns codec and value of struct x, x should be struct or pointer to struct.
+func (o *Options) value(x interface{}) (*Codec, reflect.Value, error) {
+	rv := reflect.Indirect(reflect.ValueOf(x))
+	if !rv.IsValid() {
+		return nil, rv, fmt.Errorf("stob: struct or non-nil pointer to struct is required, got %T", x)
+	}
+
+	c, err := o.Compile(rv.Type())
+	return c, rv, err
+}
+
+// grow returns b with capacity for n more bytes.
+func grow(b []byte, n int) []byte {
+	if cap(b)-len(b) >= n {
+		return b
+	}
+
+	nb := make([]byte, len(b), len(b)+n)
+	copy(nb, b)
+	return nb
+}
+
+// size returns length of encoded struct rv.
+func (c *Codec) size(rv reflect.Value) (n int, err error) {
+	return c.sizeAt(rv, 0)
+}
+
+// sizeAt returns length of encoded struct rv placed at offset base from the start of the top-level struct.
+func (c *Codec) sizeAt(rv reflect.Value, base int) (n int, err error) {
+	if c.refs {
+		// conditions may depend on filled lengths and discriminators
+		if rv, err = c.withRefs(rv); err != nil {
+			return 0, err
+		}
+	} else if c.unexported && !rv.CanAddr() {
+		rv = addr(rv).Elem()
+	}
+
+	for _, f := range c.fields {
+		l, err := f.sizeOf(rv, base, n)
+		if err != nil {
+			return n, fieldError(err, f.rsf.Name, -1)
+		}
+		n += l
+	}
+
+	return n + c.trailing(n), nil
+}
+
+// sizeOf returns length of encoded field of struct sv with padding before it, base is offset of struct from the start of the top-level struct, off is offset of field in struct.
+func (f *field) sizeOf(sv reflect.Value, base, off int) (int, error) {
+	if f.cond != nil && !f.cond.eval(sv) {
+		return 0, nil
+	}
+
+	pad, err := f.gap(sv, base, off)
+	if err != nil {
+		return 0, err
+	}
+
+	if f.nested() && f.s.pos {
+		n, err := f.s.sizeAt(f.nestedValue(f.value(sv)), base+off+pad)
+		return pad + n, err
+	}
+
+	if f.group != nil {
+		return pad + f.group.size, nil
+	}
+
+	fv := f.value(sv)
+
+	n, err := f.valueSize(fv)
+	if err != nil || f.prefix == nil {
+		return pad + n, err
+	}
+
+	// prefix holds length in bytes of opaque value, otherwise length of string or count of elements
+	l := n
+	if !f.opaque() {
+		l = fv.Len()
+	}
+
+	return pad + n + f.prefix.len(l), nil
+}
+
+// valueSize returns length of encoded value rv of field without prefix.
+func (f *field) valueSize(rv reflect.Value) (int, error) {
+	if f.magic != nil {
+		return len(f.magic), nil
+	}
+	if f.reserved != 0 {
+		return f.reserved, nil
+	}
+
+	if f.opaque() {
+		if f.size != 0 {
+			return f.size, nil
+		}
+
+		enc := f.marshalBinary
+		if f.tc != nil {
+			enc = f.encodeRegistered
+		}
+
+		b, err := enc(nil, rv)
+		return len(b), err
+	}
+
+	if reflect.PtrTo(baseType(f.rt)).Implements(readerType) {
+		return addr(rv).Interface().(Reader).Size(), nil
+	}
+
+	switch f.rk {
+	case reflect.Uint8, reflect.Bool:
+		if f.enc != 0 {
+			break
+		}
+		return 1, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if f.enc != 0 {
+			break
+		}
+		return f.size, nil
+
+	case reflect.String:
+		if f.sized() {
+			return rv.Len(), nil
+		}
+		return stringSize(rv.Len(), f.size), nil
+
+	case reflect.Struct, reflect.Ptr:
+		return structSize(f.s, rv)
+
+	case reflect.Interface:
+		if f.union == nil {
+			break
+		}
+		if rv.IsNil() {
+			return 0, fmt.Errorf("variant is nil")
+		}
+
+		c, err := compileVariant(f.o, baseType(rv.Elem().Type()))
+		if err != nil {
+			return 0, err
+		}
+		return structSize(c, rv.Elem())
+
+	case reflect.Slice, reflect.Array:
+		return f.sliceSize(rv)
+	}
+
+	if f.enc != 0 {
+		x, err := varValue(rv, f.enc)
+		return f.enc.len(x), err
+	}
+
+	return f.customSize(rv), nil
+}
+
+// structSize returns length of encoded struct or pointer to struct rv, nil pointer is encoded as zero struct.
+func structSize(c *Codec, rv reflect.Value) (int, error) {
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv = reflect.New(c.rt)
+		}
+		rv = rv.Elem()
+	}
+
+	return c.size(rv)
+}
+
+// stringSize returns length of encoded string of n bytes, it is NUL terminated if size is not set.
+func stringSize(n, size int) int {
+	if size != 0 {
+		return size
+	}
+	return n + 1
+}
+
+func (f *field) sliceSize(rv reflect.Value) (n int, err error) {
+	count := f.num
+	if count == 0 {
+		count = rv.Len()
+	}
+
+	switch f.rt.Elem().Kind() {
+	case reflect.String:
+		for i := 0; i < count; i++ {
+			l := 0
+			if i < rv.Len() {
+				l = rv.Index(i).Len()
+			}
+			n += stringSize(l, f.size)
+		}
+		return n, nil
+
+	case reflect.Uint8, reflect.Bool:
+		return count, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return count * f.size, nil
+	}
+
+	if f.s == nil {
+		return f.customSize(rv), nil
+	}
+
+	for i := 0; i < count; i++ {
+		ev := reflect.New(f.s.rt)
+		if i < rv.Len() {
+			ev = rv.Index(i)
+		}
+
+		l, err := structSize(f.s, ev)
+		if err != nil {
+			return n, fieldError(err, fmt.Sprintf("[%d]", i), -1)
+		}
+		n += l
+	}
+
+	return n, nil
+}
+
+// customSize returns length of raw memory of value, as it is copied by Custom.
+func (f *field) customSize(rv reflect.Value) int {
+	count := f.num
+	if f.rk == reflect.Slice && (f.sized() || count == 0) {
+		count = rv.Len() * int(f.rt.Elem().Size())
+	} else if count == 0 {
+		count = int(f.rt.Size())
+	}
+
+	return count
+}
@@ -0,0 +1,110 @@
+package stob
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+type SizeStruct struct {
+	Str    string
+	Strs   []string `num:"3"`
+	Sized  []string `prefix:"u8" size:"2"`
+	Ptr    *SizeSub
+	Subs   []*SubCodecStruct `prefix:"u8"`
+	Custom CustomType
+	Tail   []int32
+}
+
+type SizeSub struct {
+	Name string
+	Data []byte `prefix:"u8"`
+}
+
+func TestSizeOf(t *testing.T) {
+	for _, x := range []interface{}{
+		&SizeStruct{},
+		&SizeStruct{Str: "hello", Strs: []string{"a", "bc"}, Sized: []string{"x", "y"}, Ptr: &SizeSub{Name: "nested", Data: []byte{1}}, Subs: []*SubCodecStruct{nil, {A: 1}}, Tail: []int32{1, 2, 3}},
+		&YourStruct{Str: "string", SliceStr: []string{"a"}, PtrStruct: &SubStruct{Addr: net.HardwareAddr{1, 2, 3, 4, 5, 6}}},
+		&CondStruct{Version: 2, Flags: 0x8004, C: "text"},
+		&CondStruct{Version: 1, Ext: true},
+		&PrefixStruct{Name: "name", Data: []byte{1, 2, 3}, Names: []string{"a", "b"}, Records: []SubCodecStruct{{A: 1}}},
+		&RefStruct{Name: "ref", Records: []SubCodecStruct{{A: 1}, {B: 2}}, Data: []byte{1, 2, 3}},
+		&UnionEnvelope{Payload: &UnionText{Text: "text"}},
+		&UnionLenEnvelope{Payload: UnionPing{Seq: 1}},
+		&NumericStruct{I64: []int64{1, 2}, Bs: []bool{true}, Tail: []uint16{1, 2, 3}},
+		&RecordFile{Num: []*SubCodecStruct{{A: 1}}, Records: []SubCodecStruct{{A: 2}}, Rest: []*SubCodecStruct{nil, {B: 3}}},
+		&BitsStruct{},
+	} {
+		data, err := Marshal(x)
+		if err != nil {
+			t.Fatalf("%T: %v", x, err)
+		}
+
+		n, err := SizeOf(x)
+		if err != nil {
+			t.Fatalf("%T: %v", x, err)
+		}
+		if n != len(data) {
+			t.Errorf("%T: SizeOf returns %d, encoded %d bytes", x, n, len(data))
+		}
+
+		p := make([]byte, n+1)
+		if n, err = MarshalTo(p, x); err != nil {
+			t.Fatalf("%T: %v", x, err)
+		}
+		if !bytes.Equal(p[:n], data) {
+			t.Errorf("%T: MarshalTo writes\n% 02x\n% 02x", x, p[:n], data)
+		}
+
+		b, err := AppendMarshal([]byte{0xff}, x)
+		if err != nil {
+			t.Fatalf("%T: %v", x, err)
+		}
+		if b[0] != 0xff || !bytes.Equal(b[1:], data) {
+			t.Errorf("%T: AppendMarshal appends\n% 02x\n% 02x", x, b[1:], data)
+		}
+	}
+}
+
+func TestMarshalToShort(t *testing.T) {
+	a := SizeStruct{Str: "hello"}
+
+	n, err := SizeOf(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := make([]byte, n-1)
+	_, err = MarshalTo(p, &a)
+
+	var fe *FieldError
+	if !errors.As(err, &fe) || fe.Need != n || fe.Have != n-1 || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if !bytes.Equal(p, make([]byte, n-1)) {
+		t.Errorf("buffer is changed % 02x", p)
+	}
+}
+
+func TestAppendMarshalReuse(t *testing.T) {
+	a := SizeStruct{Str: "hello", Tail: []int32{1, 2}}
+	buf := make([]byte, 0, 256)
+
+	b, err := AppendMarshal(buf, &a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &b[:1][0] != &buf[:1][0] {
+		t.Error("buffer with enough capacity is not reused")
+	}
+
+	if b, err = AppendMarshal(buf[:250], &a); err != nil {
+		t.Fatal(err)
+	}
+	if cap(b) != 250+len(b[250:]) {
+		t.Errorf("buffer is grown to %d, expected exact size %d", cap(b), len(b))
+	}
+}
@@ -0,0 +1,260 @@
+package stob
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// buffer is source of bytes for decoding, bytes are taken either from slice or pulled from stream on demand.
+type buffer struct {
+	p   []byte
+	off int
+
+	// base is offset of p in the whole decoded data
+	base int
+
+	r   io.Reader
+	eof bool
+
+	// ra is set if data is read from io.ReaderAt, r reads from it then
+	ra io.ReaderAt
+}
+
+// next returns next n bytes.
+func (buf *buffer) next(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, &FieldError{Offset: buf.offset(), Err: fmt.Errorf("invalid length %d", n)}
+	}
+
+	if err := buf.fill(n); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, shortError(buf.offset(), n, len(buf.p)-buf.off)
+		}
+		return nil, err
+	}
+
+	p := buf.p[buf.off : buf.off+n : buf.off+n]
+	buf.off += n
+
+	return p, nil
+}
+
+// until returns bytes up to first c byte, c byte is consumed but not returned. If there is no c byte, all remaining bytes are returned, it is error if there are no bytes at all.
+func (buf *buffer) until(c byte) ([]byte, error) {
+	if i := bytes.IndexByte(buf.p[buf.off:], c); i >= 0 {
+		p := buf.p[buf.off : buf.off+i : buf.off+i]
+		buf.off += i + 1
+		return p, nil
+	}
+
+	for buf.r != nil && !buf.eof {
+		b, err := buf.readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if b == c {
+			p := buf.p[buf.off : len(buf.p)-1 : len(buf.p)-1]
+			buf.off = len(buf.p)
+			return p, nil
+		}
+	}
+
+	p, err := buf.rest()
+	if err == nil && len(p) == 0 {
+		return nil, shortError(buf.offset(), 1, 0)
+	}
+	return p, err
+}
+
+// rest returns all remaining bytes, stream is read to the end.
+func (buf *buffer) rest() ([]byte, error) {
+	if buf.r != nil && !buf.eof {
+		for {
+			if cap(buf.p)-len(buf.p) < 512 {
+				buf.p = append(buf.p, make([]byte, 512)...)[:len(buf.p)]
+			}
+
+			n, err := buf.r.Read(buf.p[len(buf.p):cap(buf.p)])
+			buf.p = buf.p[:len(buf.p)+n]
+			if err == io.EOF {
+				buf.eof = true
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	p := buf.p[buf.off:len(buf.p):len(buf.p)]
+	buf.off = len(buf.p)
+
+	return p, nil
+}
+
+// sub returns buffer of next n bytes.
+func (buf *buffer) sub(n int) (*buffer, error) {
+	base := buf.offset()
+
+	p, err := buf.next(n)
+	if err != nil {
+		return nil, err
+	}
+
+	return &buffer{p: p, base: base}, nil
+}
+
+// offset returns offset of the next byte in the whole decoded data.
+func (buf *buffer) offset() int {
+	return buf.base + buf.off
+}
+
+// more reports whether there are bytes to decode.
+func (buf *buffer) more() bool {
+	return buf.fill(1) == nil
+}
+
+// fill pulls bytes from stream until n bytes are available.
+func (buf *buffer) fill(n int) error {
+	need := buf.off + n - len(buf.p)
+	if need <= 0 {
+		return nil
+	}
+
+	if buf.r == nil || buf.eof {
+		return io.ErrUnexpectedEOF
+	}
+
+	// bytes are pulled by chunks, so broken length does not allocate more than stream has
+	for need > 0 {
+		chunk := need
+		if chunk > maxChunk {
+			chunk = maxChunk
+		}
+
+		l := len(buf.p)
+		buf.p = append(buf.p, make([]byte, chunk)...)
+
+		m, err := io.ReadFull(buf.r, buf.p[l:])
+		buf.p = buf.p[:l+m]
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				buf.eof = true
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+
+		need -= m
+	}
+
+	return nil
+}
+
+const maxChunk = 64 << 10
+
+// readByte pulls one byte from stream.
+func (buf *buffer) readByte() (b byte, err error) {
+	if br, ok := buf.r.(io.ByteReader); ok {
+		b, err = br.ReadByte()
+	} else {
+		var p [1]byte
+		_, err = io.ReadFull(buf.r, p[:])
+		b = p[0]
+	}
+
+	if err == io.EOF {
+		buf.eof = true
+		return 0, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	buf.p = append(buf.p, b)
+	return b, nil
+}
+
+//
+// Decoder
+
+// Decoder reads and decodes structs from stream, it pulls from reader exactly as many bytes as fields need.
+type Decoder struct {
+	r io.Reader
+	o *Options
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return defaultOptions.NewDecoder(r)
+}
+
+// Decode next struct from stream to x, x should be pointer to struct. At the end of stream io.EOF is returned.
+func (d *Decoder) Decode(x interface{}) error {
+	rv := reflect.ValueOf(x)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("stob: Decode requires non-nil pointer to struct")
+	}
+
+	c, err := d.o.Compile(rv.Type().Elem())
+	if err != nil {
+		return err
+	}
+
+	buf := &buffer{r: d.r}
+
+	_, err = c.write(buf, rv.Elem())
+	if len(buf.p) == 0 && buf.eof {
+		return io.EOF
+	}
+
+	return err
+}
+
+//
+// Encoder
+
+// Encoder encodes and writes structs to stream.
+type Encoder struct {
+	w io.Writer
+	b []byte
+	o *Options
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return defaultOptions.NewEncoder(w)
+}
+
+// Encode x and write it to stream, x should be struct or pointer to struct.
+func (e *Encoder) Encode(x interface{}) error {
+	if m, ok := x.(Marshaler); ok && e.o == defaultOptions {
+		var err error
+		if e.b, err = m.MarshalStob(e.b[:0]); err != nil {
+			return err
+		}
+
+		_, err = e.w.Write(e.b)
+		return err
+	}
+
+	rv := reflect.Indirect(reflect.ValueOf(x))
+	if !rv.IsValid() {
+		return errors.New("stob: Encode requires struct or non-nil pointer to struct")
+	}
+
+	c, err := e.o.Compile(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	e.b, err = c.read(e.b[:0], rv)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(e.b)
+	return err
+}
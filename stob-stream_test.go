@@ -0,0 +1,140 @@
+package stob
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+type StreamStruct struct {
+	Type  byte
+	Name  string
+	Tags  [2]string
+	Value uint32 `bo:"be"`
+	Sub   *SubCodecStruct
+}
+
+type StreamTail struct {
+	Len  uint16
+	Data []byte
+}
+
+func streamStructs() []StreamStruct {
+	return []StreamStruct{
+		{Type: 1, Name: "first", Tags: [2]string{"a", "bc"}, Value: 1, Sub: &SubCodecStruct{A: 1, B: 2}},
+		{Type: 2, Name: "", Tags: [2]string{"", "d"}, Value: 0xffffffff, Sub: &SubCodecStruct{}},
+		{Type: 3, Name: "third message", Value: 3, Sub: &SubCodecStruct{B: 0xffff}},
+	}
+}
+
+func TestEncoderDecoder(t *testing.T) {
+	ss := streamStructs()
+
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	for i := range ss {
+		if err := enc.Encode(ss[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var data []byte
+	for i := range ss {
+		p, err := Marshal(&ss[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, p...)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("encoder output differs from Marshal\n% 02x\n% 02x", buf.Bytes(), data)
+	}
+
+	for name, r := range map[string]io.Reader{
+		"one byte": iotest.OneByteReader(bytes.NewReader(data)),
+		"half":     iotest.HalfReader(bytes.NewReader(data)),
+		"bufio":    bufio.NewReader(bytes.NewReader(data)),
+	} {
+		dec := NewDecoder(r)
+		for i := range ss {
+			var x StreamStruct
+			if err := dec.Decode(&x); err != nil {
+				t.Fatal(name, i, err)
+			}
+			if !reflect.DeepEqual(x, ss[i]) {
+				t.Errorf("%s: decoded struct is not equal\n%+v\n%+v", name, x, ss[i])
+			}
+		}
+
+		var x StreamStruct
+		if err := dec.Decode(&x); err != io.EOF {
+			t.Errorf("%s: expected io.EOF, got %v", name, err)
+		}
+	}
+}
+
+func TestDecoderExactRead(t *testing.T) {
+	data, err := Marshal(&StreamStruct{Name: "name", Sub: &SubCodecStruct{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tail := []byte{0xde, 0xad}
+	r := bytes.NewReader(append(data, tail...))
+
+	var x StreamStruct
+	if err := NewDecoder(iotest.OneByteReader(r)).Decode(&x); err != nil {
+		t.Fatal(err)
+	}
+
+	rest, _ := io.ReadAll(r)
+	if !bytes.Equal(rest, tail) {
+		t.Errorf("decoder pulled more bytes than needed, rest % 02x", rest)
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	data, err := Marshal(&StreamStruct{Name: "name", Sub: &SubCodecStruct{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var x StreamStruct
+	err = NewDecoder(bytes.NewReader(data[:len(data)-1])).Decode(&x)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestDecoderRest(t *testing.T) {
+	data := []byte{0x03, 0x00, 1, 2, 3, 4, 5}
+
+	var x StreamTail
+	if err := NewDecoder(iotest.HalfReader(bytes.NewReader(data))).Decode(&x); err != nil {
+		t.Fatal(err)
+	}
+	if x.Len != 3 || !bytes.Equal(x.Data, data[2:]) {
+		t.Errorf("unexpected decoded struct %+v", x)
+	}
+}
+
+func TestMarshalLarge(t *testing.T) {
+	a := StreamStruct{Name: string(bytes.Repeat([]byte{'x'}, 2048)), Sub: &SubCodecStruct{}}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b StreamStruct
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Name != a.Name {
+		t.Error("failed restore long string")
+	}
+}
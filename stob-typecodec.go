@@ -0,0 +1,130 @@
+package stob
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// TypeCodec encodes and decodes values of registered type, it allows to support types of other packages without methods.
+type TypeCodec interface {
+	// Size returns length of encoded value for field with tags, or 0 if length is variable and value is framed by prefix or len tag, or takes all remaining bytes.
+	Size(tag Tag) int
+
+	// Encode appends encoded value v to b.
+	Encode(b []byte, v interface{}, tag Tag) ([]byte, error)
+
+	// Decode decodes p to value pointed by v, p holds exactly the bytes of value.
+	Decode(p []byte, v interface{}, tag Tag) error
+}
+
+// Tag is the parsed tags of field passed to TypeCodec.
+type Tag struct {
+	// Name of field.
+	Name string
+
+	ByteOrder ByteOrder
+
+	// Size is value of size tag, 0 if it is not set.
+	Size int
+
+	// StructTag holds all tags of field, codec can read its own ones.
+	StructTag reflect.StructTag
+}
+
+// typeCodecs is the global registry of codecs of types.
+var typeCodecs = struct {
+	sync.Mutex
+	m map[reflect.Type]TypeCodec
+
+	// used are types looked up by compiled codecs, codecs of them can not be registered anymore
+	used map[reflect.Type]bool
+}{
+	m:    make(map[reflect.Type]TypeCodec),
+	used: make(map[reflect.Type]bool),
+}
+
+// RegisterCodec registers codec of type rt for all options. Codec is used for fields of type rt and pointers to it before other ways of encoding. Compiled codecs are cached, so it panics if type rt is already used by compiled struct.
+func RegisterCodec(rt reflect.Type, tc TypeCodec) {
+	typeCodecs.Lock()
+	defer typeCodecs.Unlock()
+
+	if typeCodecs.used[rt] {
+		panic(fmt.Sprintf("stob: codec of type %s is registered after its first use", rt))
+	}
+
+	typeCodecs.m[rt] = tc
+}
+
+// RegisterCodec registers codec of type rt for options o only, it takes precedence over the global one. It panics if type rt is already used by struct compiled with options o.
+func (o *Options) RegisterCodec(rt reflect.Type, tc TypeCodec) {
+	if _, ok := o.used.Load(rt); ok {
+		panic(fmt.Sprintf("stob: codec of type %s is registered after its first use", rt))
+	}
+
+	o.types.Store(rt, tc)
+}
+
+// typeCodec returns codec registered for type rt in options o or globally, or nil. Type is marked as used, so its codec can not be changed after compiling.
+func (o *Options) typeCodec(rt reflect.Type) TypeCodec {
+	o.used.Store(rt, true)
+
+	if tc, ok := o.types.Load(rt); ok {
+		return tc.(TypeCodec)
+	}
+
+	typeCodecs.Lock()
+	defer typeCodecs.Unlock()
+
+	typeCodecs.used[rt] = true
+	return typeCodecs.m[rt]
+}
+
+//
+// registered codec
+
+// Registered encodes value by registered codec.
+func (f *field) Registered(b []byte, rv reflect.Value) ([]byte, error) {
+	return f.frame(b, rv, f.encodeRegistered)
+}
+
+func (f *field) encodeRegistered(b []byte, rv reflect.Value) ([]byte, error) {
+	return f.tc.Encode(b, addr(rv).Elem().Interface(), f.tag)
+}
+
+// SetRegistered decodes value by registered codec.
+func (f *field) SetRegistered(buf *buffer, rv reflect.Value, n int) error {
+	p, err := f.unframe(buf, n)
+	if err != nil {
+		return err
+	}
+
+	ptr := reflect.New(baseType(f.rt))
+	if err := f.tc.Decode(p, ptr.Interface(), f.tag); err != nil {
+		return err
+	}
+
+	if rv.Kind() == reflect.Ptr {
+		rv.Set(ptr)
+	} else {
+		rv.Set(ptr.Elem())
+	}
+
+	return nil
+}
+
+// initTypeCodec passes parsed tags to registered codec of field and takes size of value from it.
+func (f *field) initTypeCodec(tag reflect.StructTag) error {
+	f.tag = Tag{Name: f.rsf.Name, ByteOrder: f.e, Size: f.size, StructTag: tag}
+
+	size := f.tc.Size(f.tag)
+	if size < 0 {
+		return fmt.Errorf("stob: codec of %s returns negative size %d for field %s", f.rt, size, f.rsf.Name)
+	}
+
+	if f.size == 0 {
+		f.size = size
+	}
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package stob
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TCPrice is fixed-point price encoded as count of cents.
+type TCPrice float64
+
+type priceCodec struct{}
+
+func (priceCodec) Size(tag Tag) int {
+	if tag.Size != 0 {
+		return tag.Size
+	}
+	return 4
+}
+
+func (c priceCodec) Encode(b []byte, v interface{}, tag Tag) ([]byte, error) {
+	b, p := extend(b, c.Size(tag))
+	Itob(p, int64(math.Round(float64(v.(TCPrice))*100)), tag.ByteOrder)
+	return b, nil
+}
+
+func (priceCodec) Decode(p []byte, v interface{}, tag Tag) error {
+	*v.(*TCPrice) = TCPrice(SignExtend(Btoi(p, tag.ByteOrder), len(p))) / 100
+	return nil
+}
+
+// TCDevice is encoded as "vendor:serial".
+type TCDevice struct {
+	Vendor string
+	Serial string
+}
+
+type deviceCodec struct{}
+
+func (deviceCodec) Size(tag Tag) int {
+	return 0
+}
+
+func (deviceCodec) Encode(b []byte, v interface{}, tag Tag) ([]byte, error) {
+	d := v.(TCDevice)
+	return append(b, d.Vendor+tag.StructTag.Get("sep")+d.Serial...), nil
+}
+
+func (deviceCodec) Decode(p []byte, v interface{}, tag Tag) error {
+	vendor, serial, ok := strings.Cut(string(p), tag.StructTag.Get("sep"))
+	if !ok {
+		return errors.New("invalid device")
+	}
+
+	*v.(*TCDevice) = TCDevice{Vendor: vendor, Serial: serial}
+	return nil
+}
+
+type unixCodec struct{}
+
+func (unixCodec) Size(tag Tag) int {
+	return 4
+}
+
+func (unixCodec) Encode(b []byte, v interface{}, tag Tag) ([]byte, error) {
+	return binary.BigEndian.AppendUint32(b, uint32(v.(time.Time).Unix())), nil
+}
+
+func (unixCodec) Decode(p []byte, v interface{}, tag Tag) error {
+	*v.(*time.Time) = time.Unix(int64(binary.BigEndian.Uint32(p)), 0).UTC()
+	return nil
+}
+
+func init() {
+	RegisterCodec(reflect.TypeOf(TCPrice(0)), priceCodec{})
+	RegisterCodec(reflect.TypeOf(TCDevice{}), deviceCodec{})
+}
+
+type TCStruct struct {
+	Price  TCPrice `bo:"be"`
+	Prices *TCPrice
+	Device TCDevice `prefix:"u8" sep:":"`
+	Last   TCDevice `sep:"/"`
+}
+
+func TestRegisterCodec(t *testing.T) {
+	price := TCPrice(-0.5)
+	a := TCStruct{
+		Price:  12.34,
+		Prices: &price,
+		Device: TCDevice{Vendor: "acme", Serial: "42"},
+		Last:   TCDevice{Vendor: "x", Serial: "y"},
+	}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []byte{
+		0x00, 0x00, 0x04, 0xd2,
+		0xce, 0xff, 0xff, 0xff,
+		7, 'a', 'c', 'm', 'e', ':', '4', '2',
+		'x', '/', 'y',
+	}
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	if n, err := SizeOf(&a); err != nil || n != len(data) {
+		t.Errorf("SizeOf returns %d, %v, expected %d", n, err, len(data))
+	}
+
+	var b TCStruct
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", a, b)
+	}
+
+	// error of codec is wrapped with path of field
+	data[13] = 'x'
+	err = Unmarshal(data, &b)
+	if fe, ok := err.(*FieldError); !ok || fe.Path != "Device" || fe.Offset != 8 {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// codec receives size tag
+	short := struct {
+		Price TCPrice `size:"2"`
+	}{Price: 1}
+	if data, err := Marshal(&short); err != nil || !bytes.Equal(data, []byte{100, 0}) {
+		t.Errorf("unexpected data % 02x, %v", data, err)
+	}
+}
+
+func TestRegisterCodecOptions(t *testing.T) {
+	type event struct {
+		Time time.Time
+		Ptr  *time.Time
+	}
+
+	now := time.Unix(1700000000, 0).UTC()
+	a := event{Time: now, Ptr: &now}
+
+	o := &Options{}
+	o.RegisterCodec(reflect.TypeOf(time.Time{}), unixCodec{})
+
+	data, err := o.Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte{0x65, 0x53, 0xf1, 0x00, 0x65, 0x53, 0xf1, 0x00}) {
+		t.Errorf("unexpected data % 02x", data)
+	}
+
+	var b event
+	if err := o.Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", a, b)
+	}
+
+	// other options do not see the codec
+	if _, err := NewStruct(&a); err == nil {
+		t.Error("time without size is accepted by default options")
+	}
+}
+
+func TestRegisterCodecElements(t *testing.T) {
+	type prices struct {
+		N      uint8
+		Prices []TCPrice `count:"N"`
+	}
+	type devices struct {
+		Devices [2]*TCDevice
+	}
+
+	for _, x := range []interface{}{&prices{}, &devices{}} {
+		if _, err := NewStruct(x); err == nil || !strings.Contains(err.Error(), "own encoding") {
+			t.Errorf("%T: unexpected error %v", x, err)
+		}
+	}
+}
+
+type TCLate struct {
+	A uint8
+}
+
+func TestRegisterCodecLate(t *testing.T) {
+	type late struct {
+		Late TCLate
+	}
+
+	o := &Options{}
+	if _, err := o.Marshal(&late{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// codec registered after compiling would be ignored by cached codecs
+	for _, register := range []func(){
+		func() { o.RegisterCodec(reflect.TypeOf(TCLate{}), deviceCodec{}) },
+		func() { RegisterCodec(reflect.TypeOf(TCLate{}), deviceCodec{}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("late registration does not panic")
+				}
+			}()
+			register()
+		}()
+	}
+}
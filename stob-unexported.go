@@ -0,0 +1,81 @@
+package stob
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// exportAll reports whether unexported fields of struct rt are encoded, it is turned on by options or by blank field `_ struct{}` with tag `stob:"unexported"`.
+func exportAll(rt reflect.Type, o *Options) bool {
+	if o.Unexported {
+		return true
+	}
+
+	for i := 0; i < rt.NumField(); i++ {
+		if rsf := rt.Field(i); rsf.Name == "_" && rsf.Tag.Get("stob") == "unexported" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isMarker reports whether field is blank marker of struct settings or blank field of zero size like `_ struct{}` or `_ [0]func()`, it is not encoded.
+func isMarker(rsf reflect.StructField) bool {
+	if rsf.Name != "_" {
+		return false
+	}
+
+	if rsf.Tag.Get("pack") != "" || rsf.Tag.Get("stob") == "unexported" {
+		return true
+	}
+
+	return rsf.Type.Size() == 0 && rsf.Tag.Get("reserved") == "" && rsf.Tag.Get("magic") == "" && rsf.Tag.Get("const") == ""
+}
+
+// value returns field of struct sv. Unexported field is accessed by unsafe pointer as exported one, so struct should be addressable.
+func (f *field) value(sv reflect.Value) reflect.Value {
+	rv := sv.Field(f.index)
+	if f.unexported {
+		return reflect.NewAt(f.rt, unsafe.Pointer(rv.UnsafeAddr())).Elem()
+	}
+	return rv
+}
+
+// fieldByIndex returns field of struct sv by index, unexported field of addressable struct is settable.
+func fieldByIndex(sv reflect.Value, index []int) (reflect.Value, error) {
+	rv, err := sv.FieldByIndexErr(index)
+	if err != nil || rv.CanSet() || !rv.CanAddr() {
+		return rv, err
+	}
+
+	return reflect.NewAt(rv.Type(), unsafe.Pointer(rv.UnsafeAddr())).Elem(), nil
+}
+
+// allocByIndex returns settable field of copied struct sv by index as fieldByIndex does. Pointers of embedded structs on the way are replaced by copies of their structs, or by new ones if they are nil, so the original struct is not changed.
+func allocByIndex(sv reflect.Value, index []int) (reflect.Value, error) {
+	for _, i := range index[:len(index)-1] {
+		rv, err := fieldByIndex(sv, []int{i})
+		if err != nil {
+			return rv, err
+		}
+
+		if rv.Kind() == reflect.Ptr {
+			if !rv.CanSet() {
+				return rv, fmt.Errorf("pointer to embedded struct %s can not be set", rv.Type().Elem())
+			}
+
+			p := reflect.New(rv.Type().Elem())
+			if !rv.IsNil() {
+				p.Elem().Set(rv.Elem())
+			}
+			rv.Set(p)
+			rv = p.Elem()
+		}
+
+		sv = rv
+	}
+
+	return fieldByIndex(sv, index[len(index)-1:])
+}
@@ -0,0 +1,133 @@
+package stob
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type unexportedInner struct {
+	_    struct{} `stob:"unexported"`
+	id   uint16
+	name string `prefix:"u8"`
+}
+
+type UnexportedMessage struct {
+	_       struct{} `stob:"unexported"`
+	Type    uint8
+	length  uint8
+	flags   uint8 `bits:"4"`
+	version uint8 `bits:"4"`
+	inner   unexportedInner
+	items   []unexportedInner `prefix:"u8"`
+	Payload []byte            `len:"length"`
+}
+
+func TestUnexported(t *testing.T) {
+	a := UnexportedMessage{
+		Type:    1,
+		flags:   2,
+		version: 3,
+		inner:   unexportedInner{id: 7, name: "abc"},
+		items:   []unexportedInner{{id: 8, name: "x"}},
+		Payload: []byte{9, 9},
+	}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []byte{1, 2, 0x23, 7, 0, 3, 'a', 'b', 'c', 1, 8, 0, 1, 'x', 9, 9}
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	// struct passed by value is encoded too
+	if data, err := AppendMarshal(nil, a); err != nil || !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data % 02x, %v", data, err)
+	}
+
+	if n, err := SizeOf(a); err != nil || n != len(expect) {
+		t.Errorf("SizeOf returns %d, %v, expected %d", n, err, len(expect))
+	}
+
+	var b UnexportedMessage
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	a.length = 2
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", a, b)
+	}
+}
+
+func TestUnexportedOptions(t *testing.T) {
+	type point struct {
+		X int16
+		y int16
+	}
+
+	a := point{X: 1, y: 2}
+
+	if data, err := Marshal(&a); err != nil || !bytes.Equal(data, []byte{1, 0}) {
+		t.Errorf("unexported field is encoded by default: % 02x, %v", data, err)
+	}
+
+	o := &Options{Unexported: true}
+
+	data, err := o.Marshal(&a)
+	if err != nil || !bytes.Equal(data, []byte{1, 0, 2, 0}) {
+		t.Fatalf("unexpected data % 02x, %v", data, err)
+	}
+
+	var b point
+	if err := o.Unmarshal(data, &b); err != nil || b != a {
+		t.Errorf("unexpected struct %+v, %v", b, err)
+	}
+}
+
+// TestUnexportedConcurrent is meaningful under race detector: go test -race
+func TestUnexportedConcurrent(t *testing.T) {
+	o := &Options{Unexported: true}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				a := unexportedInner{id: uint16(i*100 + j), name: strings.Repeat("n", i)}
+
+				data, err := o.Marshal(&a)
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				var b unexportedInner
+				if err := o.Unmarshal(data, &b); err != nil {
+					errs <- err
+					return
+				}
+				if a != b {
+					errs <- fmt.Errorf("decoded %+v, expected %+v", b, a)
+					return
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
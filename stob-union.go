@@ -0,0 +1,209 @@
+package stob
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// variants is the registry of variant types of tagged unions.
+var variants = struct {
+	sync.RWMutex
+	types  map[int64][]reflect.Type
+	values map[reflect.Type]int64
+}{
+	types:  make(map[int64][]reflect.Type),
+	values: make(map[reflect.Type]int64),
+}
+
+// RegisterVariant registers type of variant with the value of discriminator.
+// Interface field with tag `switch:"Type"` is decoded to the registered type which matches value of field Type and implements interface of the field, on encoding field Type is filled automatically.
+// Variant should be struct or pointer to struct, discriminator should be integer.
+func RegisterVariant(discriminator, variant interface{}) {
+	rv := reflect.ValueOf(discriminator)
+
+	var d int64
+	switch {
+	case rv.CanInt():
+		d = rv.Int()
+	case rv.CanUint():
+		d = int64(rv.Uint())
+	default:
+		panic(fmt.Sprintf("stob: discriminator %v of variant %T is not integer", discriminator, variant))
+	}
+
+	rt := reflect.TypeOf(variant)
+	if rt == nil || baseType(rt).Kind() != reflect.Struct {
+		panic(fmt.Sprintf("stob: variant %T is not struct", variant))
+	}
+
+	variants.Lock()
+	defer variants.Unlock()
+
+	if v, ok := variants.values[rt]; ok {
+		if v != d {
+			panic(fmt.Sprintf("stob: variant %s is already registered with discriminator %d", rt, v))
+		}
+		return
+	}
+
+	variants.values[rt] = d
+	variants.types[d] = append(variants.types[d], rt)
+}
+
+// union is the interface field which concrete type is selected by value of discriminator field, tag `switch:"Type"`.
+type union struct {
+	name  string
+	index []int
+	it    reflect.Type
+	o     *Options
+}
+
+// resolve looks up discriminator field in struct type rt, it should be integer field placed before field f.
+func (u *union) resolve(rt reflect.Type, f *field) error {
+	sf, err := lookupIntField(rt, f, u.name)
+	if err != nil {
+		return err
+	}
+
+	u.index = sf.Index
+	return nil
+}
+
+// variant returns registered type for discriminator d which implements interface of field.
+func (u *union) variant(d int64) (vt reflect.Type, err error) {
+	variants.RLock()
+	defer variants.RUnlock()
+
+	for _, rt := range variants.types[d] {
+		if !rt.Implements(u.it) {
+			continue
+		}
+
+		if vt != nil {
+			return nil, fmt.Errorf("ambiguous variants %s and %s for discriminator %d", vt, rt, d)
+		}
+		vt = rt
+	}
+
+	if vt == nil {
+		return nil, fmt.Errorf("unknown variant for discriminator %d", d)
+	}
+
+	return vt, nil
+}
+
+// set stores discriminator of variant fv to struct sv.
+func (u *union) set(sv, fv reflect.Value) error {
+	if fv.IsNil() {
+		return fmt.Errorf("variant is nil")
+	}
+
+	rt := fv.Elem().Type()
+
+	variants.RLock()
+	d, ok := variants.values[rt]
+	variants.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("variant %s is not registered", rt)
+	}
+
+	rv, err := allocByIndex(sv, u.index)
+	if err != nil {
+		return err
+	}
+
+	return setInt(rv, d)
+}
+
+// decode reads discriminator from struct sv and decodes the variant to interface field rv.
+func (u *union) decode(buf *buffer, sv, rv reflect.Value) error {
+	dv, err := fieldByIndex(sv, u.index)
+	if err != nil {
+		return err
+	}
+
+	vt, err := u.variant(intValue(dv))
+	if err != nil {
+		return err
+	}
+
+	c, err := compileVariant(u.o, baseType(vt))
+	if err != nil {
+		return err
+	}
+
+	ptr := reflect.New(c.rt)
+	if _, err := c.write(buf, ptr.Elem()); err != nil {
+		return err
+	}
+
+	if vt.Kind() == reflect.Ptr {
+		rv.Set(ptr)
+	} else {
+		rv.Set(ptr.Elem())
+	}
+
+	return nil
+}
+
+//
+// variant
+
+func (f *field) Variant(b []byte, rv reflect.Value) ([]byte, error) {
+	if rv.IsNil() {
+		return b, fmt.Errorf("variant is nil")
+	}
+
+	v := rv.Elem()
+
+	c, err := compileVariant(f.o, baseType(v.Type()))
+	if err != nil {
+		return b, err
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v = reflect.New(c.rt)
+		}
+		v = v.Elem()
+	}
+
+	return c.read(b, v)
+}
+
+// compileVariant returns codec of variant type rt. Variant is placed wherever interface field is, so it can not have fields with offset.
+func compileVariant(o *Options, rt reflect.Type) (*Codec, error) {
+	c, err := o.Compile(rt)
+	if err == nil && c.pos {
+		return nil, fmt.Errorf("variant %s has fields with offset, absolute offsets are not supported in variants", rt)
+	}
+	return c, err
+}
+
+// intValue returns value of integer rv.
+func intValue(rv reflect.Value) int64 {
+	if rv.CanInt() {
+		return rv.Int()
+	}
+	return int64(rv.Uint())
+}
+
+// setInt stores x to integer rv, returns error if x overflows it.
+func setInt(rv reflect.Value, x int64) error {
+	if rv.CanInt() {
+		if rv.OverflowInt(x) {
+			return fmt.Errorf("value %d overflows %s", x, rv.Type())
+		}
+		rv.SetInt(x)
+		return nil
+	}
+
+	if x < 0 || rv.OverflowUint(uint64(x)) {
+		return fmt.Errorf("value %d overflows %s", x, rv.Type())
+	}
+	rv.SetUint(uint64(x))
+
+	return nil
+}
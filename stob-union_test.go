@@ -0,0 +1,200 @@
+package stob
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type UnionMessage interface {
+	message()
+}
+
+type UnionPing struct {
+	Seq uint16 `bo:"be"`
+}
+
+func (UnionPing) message() {}
+
+type UnionText struct {
+	Text string `prefix:"u8"`
+}
+
+func (*UnionText) message() {}
+
+type UnionUnknown struct {
+	A byte
+}
+
+func (UnionUnknown) message() {}
+
+type UnionEnvelope struct {
+	MsgType byte
+	Payload UnionMessage `switch:"MsgType"`
+	Tail    byte
+}
+
+type UnionLenEnvelope struct {
+	Length  uint16       `bo:"be"`
+	MsgType uint8        `bo:"be"`
+	Payload UnionMessage `switch:"MsgType" len:"Length"`
+	Tail    byte
+}
+
+func init() {
+	RegisterVariant(1, UnionPing{})
+	RegisterVariant(uint8(2), &UnionText{})
+}
+
+func TestUnion(t *testing.T) {
+	for _, test := range []struct {
+		a    UnionEnvelope
+		data []byte
+	}{
+		{
+			a:    UnionEnvelope{MsgType: 1, Payload: UnionPing{Seq: 0x0102}, Tail: 9},
+			data: []byte{0x01, 0x01, 0x02, 0x09},
+		},
+		{
+			a:    UnionEnvelope{MsgType: 2, Payload: &UnionText{Text: "hi"}, Tail: 9},
+			data: []byte{0x02, 0x02, 'h', 'i', 0x09},
+		},
+	} {
+		// discriminator is filled automatically
+		a := test.a
+		a.MsgType = 0
+
+		data, err := Marshal(&a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, test.data) {
+			t.Errorf("unexpected data\n% 02x\n% 02x", data, test.data)
+		}
+
+		var b UnionEnvelope
+		if err := Unmarshal(data, &b); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(b, test.a) {
+			t.Errorf("decoded struct is not equal\n%+v\n%+v", b, test.a)
+		}
+	}
+}
+
+func TestUnionStream(t *testing.T) {
+	var buf bytes.Buffer
+
+	enc := NewEncoder(&buf)
+	msgs := []UnionEnvelope{
+		{MsgType: 1, Payload: UnionPing{Seq: 7}},
+		{MsgType: 2, Payload: &UnionText{Text: "text"}, Tail: 1},
+	}
+	for _, m := range msgs {
+		if err := enc.Encode(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dec := NewDecoder(&buf)
+	for _, m := range msgs {
+		var b UnionEnvelope
+		if err := dec.Decode(&b); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(b, m) {
+			t.Errorf("decoded struct is not equal\n%+v\n%+v", b, m)
+		}
+	}
+
+	if err := dec.Decode(&UnionEnvelope{}); err != io.EOF {
+		t.Errorf("unexpected error at the end of stream: %v", err)
+	}
+}
+
+func TestUnionLen(t *testing.T) {
+	a := UnionLenEnvelope{Payload: &UnionText{Text: "abc"}, Tail: 5}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []byte{0x00, 0x04, 0x02, 0x03, 'a', 'b', 'c', 0x05}
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	var b UnionLenEnvelope
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Length != 4 || b.MsgType != 2 || !reflect.DeepEqual(b.Payload, a.Payload) || b.Tail != 5 {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", b, a)
+	}
+}
+
+func TestUnionErrors(t *testing.T) {
+	if _, err := Marshal(&UnionEnvelope{}); err == nil || !strings.Contains(err.Error(), "nil") {
+		t.Errorf("nil variant is encoded: %v", err)
+	}
+
+	if _, err := Marshal(&UnionEnvelope{Payload: UnionUnknown{}}); err == nil || !strings.Contains(err.Error(), "not registered") {
+		t.Errorf("unregistered variant is encoded: %v", err)
+	}
+
+	if err := Unmarshal([]byte{0x03, 0x00, 0x00}, &UnionEnvelope{}); err == nil || !strings.Contains(err.Error(), "unknown variant") {
+		t.Errorf("unknown discriminator is decoded: %v", err)
+	}
+
+	// value variant is registered, pointer is not
+	if _, err := Marshal(&UnionEnvelope{Payload: &UnionPing{}}); err == nil {
+		t.Error("unregistered pointer variant is encoded")
+	}
+}
+
+func TestUnionTagErrors(t *testing.T) {
+	for _, x := range []interface{}{
+		&struct {
+			A byte `switch:"B"`
+		}{},
+		&struct {
+			A UnionMessage `switch:"B"`
+		}{},
+		&struct {
+			A UnionMessage `switch:"B"`
+			B byte
+		}{},
+		&struct {
+			B string
+			A UnionMessage `switch:"B"`
+		}{},
+		&struct {
+			B byte
+			A UnionMessage `switch:"B" count:"B"`
+		}{},
+	} {
+		if _, err := NewStruct(x); err == nil {
+			t.Errorf("invalid union %T is compiled", x)
+		}
+	}
+}
+
+func TestRegisterVariantPanics(t *testing.T) {
+	for _, f := range []func(){
+		func() { RegisterVariant("a", UnionPing{}) },
+		func() { RegisterVariant(1, 5) },
+		func() { RegisterVariant(3, UnionPing{}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("RegisterVariant does not panic")
+				}
+			}()
+			f()
+		}()
+	}
+}
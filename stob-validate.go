@@ -0,0 +1,152 @@
+package stob
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// tagInt parses non-negative integer value of tag name, empty tag is 0.
+func tagInt(tag reflect.StructTag, name string) (int, error) {
+	s := tag.Get(name)
+	if s == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s tag %q", name, s)
+	}
+
+	return n, nil
+}
+
+// validate checks that tags of field are meaningful for its type and the type is supported, it is skipped with Lenient options.
+func (f *field) validate(tag reflect.StructTag) error {
+	if f.e != LittleEndian && f.e != BigEndian {
+		return fmt.Errorf("stob: unknown byte order %q of field %s", f.e, f.rsf.Name)
+	}
+
+	// types with own Read and Write methods know their layout
+	if f.tc == nil && reflect.PtrTo(baseType(f.rt)).Implements(readerType) {
+		return nil
+	}
+
+	// types with standard marshaling methods or registered codecs are framed by size, prefix or len tag
+	if f.opaque() {
+		if f.num != 0 {
+			return fmt.Errorf("stob: num tag of field %s is not allowed for %s", f.rsf.Name, f.rt)
+		}
+		return nil
+	}
+
+	rt := f.rt
+	if f.rk == reflect.Slice || f.rk == reflect.Array {
+		rt = f.rt.Elem()
+	}
+
+	if f.num != 0 && f.rk != reflect.Slice {
+		return fmt.Errorf("stob: num tag of field %s is allowed only for slices", f.rsf.Name)
+	}
+
+	if tag.Get("size") != "" && f.enc != 0 {
+		return fmt.Errorf("stob: size tag of field %s is not allowed with enc tag", f.rsf.Name)
+	}
+
+	if tag.Get("size") != "" {
+		switch rt.Kind() {
+		case reflect.String:
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if f.size == 0 || f.size > int(rt.Size()) {
+				return fmt.Errorf("stob: size %d of field %s does not fit %s", f.size, f.rsf.Name, rt)
+			}
+		default:
+			return fmt.Errorf("stob: size tag of field %s is not allowed for %s", f.rsf.Name, rt)
+		}
+	}
+
+	switch f.rk {
+	case reflect.Interface:
+		if f.union == nil {
+			return fmt.Errorf("stob: interface field %s should have switch tag", f.rsf.Name)
+		}
+		return nil
+
+	case reflect.Slice, reflect.Array:
+		// elements are encoded by kind, so methods and registered codecs of element type would be ignored
+		if et := baseType(rt); f.o.typeCodec(et) != nil || isBinary(et) {
+			return fmt.Errorf("stob: elements of field %s have type %s with own encoding, it is supported only for single fields", f.rsf.Name, et)
+		}
+
+		switch rt.Kind() {
+		case reflect.String, reflect.Bool,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return nil
+		case reflect.Struct:
+			return nil
+		case reflect.Ptr:
+			if rt.Elem().Kind() == reflect.Struct {
+				return nil
+			}
+		}
+
+	case reflect.Struct, reflect.Ptr, reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return nil
+	}
+
+	// everything else is copied as raw memory
+	if !isPlain(rt) {
+		return fmt.Errorf("stob: unsupported type %s of field %s", f.rt, f.rsf.Name)
+	}
+
+	return nil
+}
+
+// isPlain reports whether memory of type rt is the plain data without pointers and platform dependent sizes.
+func isPlain(rt reflect.Type) bool {
+	switch rt.Kind() {
+	case reflect.Bool,
+		reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return true
+
+	case reflect.Array:
+		return isPlain(rt.Elem())
+
+	case reflect.Struct:
+		for i := 0; i < rt.NumField(); i++ {
+			if !isPlain(rt.Field(i).Type) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// unbounded reports whether field takes all remaining bytes on decoding.
+func (f *field) unbounded() bool {
+	if f.sized() || f.magic != nil || f.reserved != 0 || reflect.PtrTo(baseType(f.rt)).Implements(writerType) {
+		return false
+	}
+
+	if f.opaque() {
+		return f.size == 0
+	}
+
+	switch f.rk {
+	case reflect.Slice:
+		return f.num == 0
+	case reflect.Struct, reflect.Ptr:
+		return f.s != nil && f.s.tail
+	}
+
+	return false
+}
@@ -0,0 +1,157 @@
+package stob
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type ValidateTail struct {
+	A    byte
+	Rest []byte
+}
+
+func TestValidate(t *testing.T) {
+	for _, test := range []struct {
+		x   interface{}
+		err string
+	}{
+		{&struct {
+			A int32 `size:"x"`
+		}{}, `invalid size tag "x"`},
+		{&struct {
+			A []byte `num:"-1"`
+		}{}, `invalid num tag "-1"`},
+		{&struct {
+			A int32 `bo:"network"`
+		}{}, `unknown byte order "network"`},
+		{&struct {
+			A int16 `size:"4"`
+		}{}, "does not fit int16"},
+		{&struct {
+			A []uint16 `num:"2" size:"3"`
+		}{}, "does not fit uint16"},
+		{&struct {
+			A float32 `size:"2"`
+		}{}, "size tag of field A is not allowed"},
+		{&struct {
+			A SubCodecStruct `size:"2"`
+		}{}, "size tag of field A is not allowed"},
+		{&struct {
+			A int32 `num:"2"`
+		}{}, "num tag of field A is allowed only for slices"},
+		{&struct {
+			A [4]byte `num:"2"`
+		}{}, "num tag of field A is allowed only for slices"},
+		{&struct {
+			A map[string]int
+		}{}, "unsupported type"},
+		{&struct {
+			A []*int `num:"2"`
+		}{}, "unsupported type"},
+		{&struct {
+			A interface{}
+		}{}, "should have switch tag"},
+		{&struct {
+			A []byte
+			B byte
+		}{}, "field A takes all remaining bytes"},
+		{&struct {
+			A []SubCodecStruct
+			B byte
+		}{}, "field A takes all remaining bytes"},
+		{&struct {
+			A ValidateTail
+			B byte
+		}{}, "field A takes all remaining bytes"},
+	} {
+		_, err := NewStruct(test.x)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%T: expected error %q, got %v", test.x, test.err, err)
+		}
+
+		if _, err := Lenient.NewStruct(test.x); err != nil {
+			t.Errorf("%T: lenient options return error %v", test.x, err)
+		}
+	}
+
+	type prefix struct {
+		A string `prefix:"u16,network"`
+	}
+	if _, err := Lenient.NewStruct(&prefix{}); err == nil {
+		t.Error("unknown byte order of prefix is accepted")
+	}
+}
+
+func TestValidateBounded(t *testing.T) {
+	for _, x := range []interface{}{
+		&ValidateTail{},
+		&struct {
+			A []byte `num:"2"`
+			B ValidateTail
+		}{},
+		&struct {
+			N byte
+			A []byte `len:"N"`
+			B []byte `prefix:"u8"`
+			C []byte
+		}{},
+		&struct {
+			A [2]float32
+			B []float64 `num:"2"`
+			C int8      `size:"1"`
+			D string    `size:"4"`
+			E []string  `num:"1" size:"4"`
+		}{},
+	} {
+		if _, err := NewStruct(x); err != nil {
+			t.Errorf("%T: %v", x, err)
+		}
+	}
+}
+
+func TestLenient(t *testing.T) {
+	type legacy struct {
+		A uint16 `size:"x"`
+		B []byte
+		C byte
+	}
+
+	a := legacy{A: 0x0102, B: []byte{3}, C: 4}
+
+	if _, err := Marshal(&a); err == nil {
+		t.Error("invalid struct is encoded")
+	}
+
+	data, err := Lenient.Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte{0x02, 0x01, 0x03, 0x04}) {
+		t.Errorf("unexpected data % 02x", data)
+	}
+
+	// B takes all remaining bytes, nothing is left for C
+	var b legacy
+	err = Lenient.Unmarshal(data, &b)
+	if fe, ok := err.(*FieldError); !ok || fe.Path != "C" {
+		t.Errorf("unexpected error %v", err)
+	}
+	if b.A != a.A || !bytes.Equal(b.B, []byte{3, 4}) {
+		t.Errorf("unexpected struct %+v", b)
+	}
+
+	// codecs are cached per options
+	c1, err := Compile(reflect.TypeOf(CodecStruct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := Lenient.Compile(reflect.TypeOf(CodecStruct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 == c2 {
+		t.Error("codecs of different options are shared")
+	}
+}
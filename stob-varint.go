@@ -0,0 +1,238 @@
+package stob
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// varEnc is the variable-length encoding of integers, tag `enc:"uvarint"`. On strings and slices it is the encoding of length prefix.
+type varEnc int
+
+const (
+	encUvarint     varEnc = iota + 1 // base 128 varint of unsigned value, as in protobuf
+	encVarint                        // zigzag varint of signed value
+	encLEB128                        // unsigned LEB128, the same bytes as uvarint
+	encSLEB128                       // signed LEB128, as in WebAssembly and DWARF
+	encMQTT                          // remaining length of MQTT, up to 4 bytes
+	encCompactSize                   // Bitcoin CompactSize
+)
+
+var varEncNames = map[string]varEnc{
+	"uvarint":     encUvarint,
+	"varint":      encVarint,
+	"leb128":      encLEB128,
+	"sleb128":     encSLEB128,
+	"mqtt":        encMQTT,
+	"compactsize": encCompactSize,
+}
+
+// parseVarEnc parses value of enc tag.
+func parseVarEnc(s string) (varEnc, error) {
+	e, ok := varEncNames[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown encoding %q", s)
+	}
+
+	return e, nil
+}
+
+// signed reports whether encoding keeps sign of value, values of such encodings are int64 stored in uint64.
+func (e varEnc) signed() bool {
+	return e == encVarint || e == encSLEB128
+}
+
+const maxMQTT = 1<<28 - 1
+
+// put appends encoded x to b.
+func (e varEnc) put(b []byte, x uint64) ([]byte, error) {
+	switch e {
+	case encVarint:
+		return binary.AppendVarint(b, int64(x)), nil
+
+	case encSLEB128:
+		v := int64(x)
+		for {
+			c := byte(v & 0x7f)
+			v >>= 7
+			if v == 0 && c&0x40 == 0 || v == -1 && c&0x40 != 0 {
+				return append(b, c), nil
+			}
+			b = append(b, c|0x80)
+		}
+
+	case encMQTT:
+		if x > maxMQTT {
+			return b, fmt.Errorf("value %d overflows mqtt encoding", x)
+		}
+
+	case encCompactSize:
+		switch {
+		case x < 0xfd:
+			return append(b, byte(x)), nil
+		case x <= math.MaxUint16:
+			return binary.LittleEndian.AppendUint16(append(b, 0xfd), uint16(x)), nil
+		case x <= math.MaxUint32:
+			return binary.LittleEndian.AppendUint32(append(b, 0xfe), uint32(x)), nil
+		}
+		return binary.LittleEndian.AppendUint64(append(b, 0xff), x), nil
+	}
+
+	return binary.AppendUvarint(b, x), nil
+}
+
+// get reads encoded value from buffer.
+func (e varEnc) get(buf *buffer) (uint64, error) {
+	if e == encCompactSize {
+		return getCompactSize(buf)
+	}
+
+	max := binary.MaxVarintLen64
+	if e == encMQTT {
+		max = 4
+	}
+
+	var x uint64
+	var shift uint
+
+	for i := 0; i < max; i++ {
+		p, err := buf.next(1)
+		if err != nil {
+			return 0, err
+		}
+		c := p[0]
+
+		// the last byte holds only the highest bit of value
+		if i == binary.MaxVarintLen64-1 && (e == encSLEB128 && c != 0 && c != 0x7f || e != encSLEB128 && c > 1) {
+			break
+		}
+
+		x |= uint64(c&0x7f) << shift
+		shift += 7
+
+		if c&0x80 != 0 {
+			continue
+		}
+
+		switch e {
+		case encVarint:
+			x = x>>1 ^ -(x & 1)
+		case encSLEB128:
+			if shift < 64 && c&0x40 != 0 {
+				x |= ^uint64(0) << shift
+			}
+		}
+
+		return x, nil
+	}
+
+	return 0, fmt.Errorf("%s value overflows %d bytes", e, max)
+}
+
+// getCompactSize reads Bitcoin CompactSize, non-canonical forms are rejected.
+func getCompactSize(buf *buffer) (uint64, error) {
+	p, err := buf.next(1)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	var min uint64
+	switch p[0] {
+	case 0xfd:
+		n, min = 2, 0xfd
+	case 0xfe:
+		n, min = 4, math.MaxUint16+1
+	case 0xff:
+		n, min = 8, math.MaxUint32+1
+	default:
+		return uint64(p[0]), nil
+	}
+
+	if p, err = buf.next(n); err != nil {
+		return 0, err
+	}
+
+	x := uint64(Btoi(p, LittleEndian))
+	if x < min {
+		return 0, fmt.Errorf("non-canonical compact size %d", x)
+	}
+
+	return x, nil
+}
+
+// len returns length of encoded x.
+func (e varEnc) len(x uint64) int {
+	var a [binary.MaxVarintLen64]byte
+	b, _ := e.put(a[:0], x)
+	return len(b)
+}
+
+func (e varEnc) String() string {
+	for name, v := range varEncNames {
+		if v == e {
+			return name
+		}
+	}
+	return fmt.Sprintf("varEnc(%d)", int(e))
+}
+
+//
+// variable-length integers
+
+// varValue returns value of integer rv for encoding enc.
+func varValue(rv reflect.Value, enc varEnc) (uint64, error) {
+	if rv.CanInt() {
+		x := rv.Int()
+		if x < 0 && !enc.signed() {
+			return 0, fmt.Errorf("negative value %d for %s encoding", x, enc)
+		}
+		return uint64(x), nil
+	}
+
+	x := rv.Uint()
+	if x > math.MaxInt64 && enc.signed() {
+		return 0, fmt.Errorf("value %d overflows %s encoding", x, enc)
+	}
+	return x, nil
+}
+
+// Varint encodes integer with variable-length encoding.
+func (f *field) Varint(b []byte, rv reflect.Value) ([]byte, error) {
+	x, err := varValue(rv, f.enc)
+	if err != nil {
+		return b, err
+	}
+
+	return f.enc.put(b, x)
+}
+
+// SetVarint decodes integer with variable-length encoding, values which do not fit the field are rejected.
+func (f *field) SetVarint(buf *buffer, rv reflect.Value, n int) error {
+	x, err := f.enc.get(buf)
+	if err != nil {
+		return err
+	}
+
+	if rv.CanInt() {
+		if !f.enc.signed() && x > math.MaxInt64 {
+			return fmt.Errorf("value %d overflows %s", x, rv.Type())
+		}
+		if rv.OverflowInt(int64(x)) {
+			return fmt.Errorf("value %d overflows %s", int64(x), rv.Type())
+		}
+		rv.SetInt(int64(x))
+		return nil
+	}
+
+	if f.enc.signed() && int64(x) < 0 {
+		return fmt.Errorf("value %d overflows %s", int64(x), rv.Type())
+	}
+	if rv.OverflowUint(x) {
+		return fmt.Errorf("value %d overflows %s", x, rv.Type())
+	}
+	rv.SetUint(x)
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package stob
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// varintStruct returns pointer to struct with the only field X of type rt with tag.
+func varintStruct(rt reflect.Type, tag string) reflect.Value {
+	st := reflect.StructOf([]reflect.StructField{{Name: "X", Type: rt, Tag: reflect.StructTag(tag)}})
+	return reflect.New(st)
+}
+
+func TestVarint(t *testing.T) {
+	for _, test := range []struct {
+		enc  string
+		x    interface{}
+		data []byte
+	}{
+		{"uvarint", uint64(0), []byte{0x00}},
+		{"uvarint", uint32(300), []byte{0xac, 0x02}},
+		{"uvarint", uint64(math.MaxUint64), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"varint", int64(-1), []byte{0x01}},
+		{"varint", int16(1), []byte{0x02}},
+		{"varint", int64(-64), []byte{0x7f}},
+		{"varint", int64(math.MinInt64), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"leb128", uint32(624485), []byte{0xe5, 0x8e, 0x26}},
+		{"sleb128", int32(-123456), []byte{0xc0, 0xbb, 0x78}},
+		{"sleb128", int8(-1), []byte{0x7f}},
+		{"sleb128", int64(63), []byte{0x3f}},
+		{"sleb128", int64(64), []byte{0xc0, 0x00}},
+		{"sleb128", int64(math.MinInt64), []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x7f}},
+		{"mqtt", uint32(321), []byte{0xc1, 0x02}},
+		{"mqtt", 268435455, []byte{0xff, 0xff, 0xff, 0x7f}},
+		{"compactsize", uint8(0xfc), []byte{0xfc}},
+		{"compactsize", uint16(0xfd), []byte{0xfd, 0xfd, 0x00}},
+		{"compactsize", uint32(0x10000), []byte{0xfe, 0x00, 0x00, 0x01, 0x00}},
+		{"compactsize", uint64(1 << 32), []byte{0xff, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00}},
+	} {
+		name := fmt.Sprintf("%s %T(%v)", test.enc, test.x, test.x)
+
+		a := varintStruct(reflect.TypeOf(test.x), `enc:"`+test.enc+`"`)
+		a.Elem().Field(0).Set(reflect.ValueOf(test.x))
+
+		data, err := Marshal(a.Interface())
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(data, test.data) {
+			t.Errorf("%s: unexpected data % 02x", name, data)
+		}
+
+		if n, err := SizeOf(a.Interface()); err != nil || n != len(data) {
+			t.Errorf("%s: SizeOf returns %d, %v", name, n, err)
+		}
+
+		b := reflect.New(a.Type().Elem())
+		if err := Unmarshal(test.data, b.Interface()); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if b.Elem().Field(0).Interface() != test.x {
+			t.Errorf("%s: decoded %v", name, b.Elem().Field(0))
+		}
+	}
+}
+
+func TestVarintErrors(t *testing.T) {
+	for _, test := range []struct {
+		enc  string
+		x    interface{}
+		data []byte
+		err  string
+	}{
+		{"uvarint", int8(0), []byte{0x80, 0x01}, "value 128 overflows int8"},
+		{"varint", int8(0), []byte{0x81, 0x02}, "value -129 overflows int8"},
+		{"sleb128", uint8(0), []byte{0x7f}, "value -1 overflows uint8"},
+		{"uvarint", uint64(0), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02}, "overflows 10 bytes"},
+		{"sleb128", int64(0), []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, "overflows 10 bytes"},
+		{"mqtt", uint32(0), []byte{0x80, 0x80, 0x80, 0x80, 0x01}, "overflows 4 bytes"},
+		{"compactsize", uint32(0), []byte{0xfd, 0x10, 0x00}, "non-canonical"},
+		{"uvarint", uint32(0), []byte{0x80}, "unexpected EOF"},
+	} {
+		b := varintStruct(reflect.TypeOf(test.x), `enc:"`+test.enc+`"`)
+
+		err := Unmarshal(test.data, b.Interface())
+		if fe, ok := err.(*FieldError); !ok || fe.Path != "X" || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s % 02x: expected error %q, got %v", test.enc, test.data, test.err, err)
+		}
+	}
+
+	for _, test := range []struct {
+		enc string
+		x   interface{}
+		err string
+	}{
+		{"uvarint", int32(-1), "negative value -1"},
+		{"varint", uint64(math.MaxUint64), "overflows varint encoding"},
+		{"mqtt", 268435456, "overflows mqtt encoding"},
+	} {
+		a := varintStruct(reflect.TypeOf(test.x), `enc:"`+test.enc+`"`)
+		a.Elem().Field(0).Set(reflect.ValueOf(test.x))
+
+		if _, err := Marshal(a.Interface()); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s %v: expected error %q, got %v", test.enc, test.x, test.err, err)
+		}
+	}
+}
+
+func TestVarintTag(t *testing.T) {
+	for _, test := range []struct {
+		rt  reflect.Type
+		tag string
+		err string
+	}{
+		{reflect.TypeOf(0), `enc:"base128"`, `unknown encoding "base128"`},
+		{reflect.TypeOf(0.0), `enc:"varint"`, "allowed only for integers, strings and slices"},
+		{reflect.TypeOf(0), `enc:"varint" size:"2"`, "not allowed with enc tag"},
+		{reflect.TypeOf(""), `enc:"varint" prefix:"u8"`, "both prefix and enc tags"},
+		{reflect.TypeOf(uint8(0)), `enc:"uvarint" bits:"4"`, "can not have enc tag"},
+		{reflect.TypeOf(""), `prefix:"uvarint,be"`, "variable-length prefix does not have byte order"},
+	} {
+		_, err := NewStruct(varintStruct(test.rt, test.tag).Interface())
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error %q, got %v", test.tag, test.err, err)
+		}
+	}
+}
+
+type VarintMessage struct {
+	Type    uint8
+	Length  uint32   `enc:"mqtt"`
+	ID      int64    `enc:"varint"`
+	Topic   string   `enc:"uvarint"`
+	Values  []int32  `prefix:"compactsize"`
+	Names   []string `enc:"leb128"`
+	Payload []byte   `prefix:"sleb128"`
+}
+
+func TestVarintPrefix(t *testing.T) {
+	a := VarintMessage{
+		Type:    3,
+		Length:  200,
+		ID:      -2,
+		Topic:   strings.Repeat("t", 130),
+		Values:  []int32{1, 2},
+		Names:   []string{"a", "b"},
+		Payload: []byte{1, 2, 3},
+	}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []byte{3, 0xc8, 0x01, 0x03, 0x82, 0x01}
+	expect = append(expect, a.Topic...)
+	expect = append(expect, 2, 1, 0, 0, 0, 2, 0, 0, 0)
+	expect = append(expect, 2, 'a', 0, 'b', 0)
+	expect = append(expect, 3, 1, 2, 3)
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	if n, err := SizeOf(&a); err != nil || n != len(data) {
+		t.Errorf("SizeOf returns %d, %v, expected %d", n, err, len(data))
+	}
+
+	var b VarintMessage
+	if err := NewDecoder(bytes.NewReader(data)).Decode(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", a, b)
+	}
+}
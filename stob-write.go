@@ -1,6 +1,7 @@
 package stob
 
 import (
+	"fmt"
 	"io"
 	"math"
 	"reflect"
@@ -11,49 +12,142 @@ type Writer interface {
 	Size
 }
 
+var writerType = reflect.TypeOf((*Writer)(nil)).Elem()
+
 func (s *Struct) Write(p []byte) (n int, err error) {
-	for _, f := range s.fields {
+	return s.c.write(&buffer{p: p}, s.rv)
+}
+
+func (c *Codec) write(buf *buffer, rv reflect.Value) (n int, err error) {
+	return c.writeAt(buf, rv, buf.offset())
+}
+
+// writeAt decodes struct rv from buf, origin is offset of the top-level struct in decoded data, positions of fields are taken from it.
+func (c *Codec) writeAt(buf *buffer, rv reflect.Value, origin int) (n int, err error) {
+	start := buf.offset()
+
+	// end is the farthest decoded byte, fields with offset may be placed before previous ones
+	end := start
 
-		// log.Println(f.rsf.Name, f.len, n, len(p))
+	var spans []span
+	if c.sums && !c.o.SkipChecksum {
+		spans = make([]span, len(c.fields))
+	}
+
+	for i, f := range c.fields {
+
+		// log.Println(f.rsf.Name, f.len, buf.off, len(buf.p))
 
-		if f.len+n > len(p) {
-			return n, io.ErrUnexpectedEOF
+		offset := buf.offset()
+		if err := f.decode(buf, rv, start, offset-start, origin); err != nil {
+			return offset - start, fieldError(err, f.rsf.Name, offset)
 		}
 
-		nw, err := f.Write(p[n:])
-		if err != nil {
-			return n, err
+		if spans != nil {
+			spans[i] = f.span(rv, start, offset, buf.offset())
 		}
 
-		n += nw
+		if buf.offset() > end {
+			end = buf.offset()
+		}
 	}
 
-	return
-}
+	if spans != nil {
+		if err := c.checkSums(buf, spans); err != nil {
+			return buf.offset() - start, err
+		}
+	}
 
-type fieldWriter func(p []byte) (int, error)
+	if end != buf.offset() {
+		if err := buf.seek(end); err != nil {
+			return buf.offset() - start, err
+		}
+	}
 
-func (f *field) setWriter() (err error) {
-	rt := f.rv.Type()
-	if f.rv.Kind() == reflect.Ptr {
-		rt = f.rv.Type().Elem()
+	if _, err := buf.next(c.trailing(end - start)); err != nil {
+		return buf.offset() - start, err
 	}
 
-	rv := reflect.New(rt)
+	return buf.offset() - start, nil
+}
+
+// decode decodes field of struct sv, start is offset of struct in the whole decoded data, off is offset of field in struct, origin is offset of the top-level struct.
+func (f *field) decode(buf *buffer, sv reflect.Value, start, off, origin int) error {
+	if f.cond != nil && !f.cond.eval(sv) {
+		rv := f.value(sv)
+		rv.Set(reflect.Zero(f.rt))
+		return nil
+	}
 
-	if w, ok := rv.Interface().(Writer); ok {
-		f.rk = f.rv.Kind()
+	if err := f.seek(buf, sv, start, off, origin); err != nil {
+		return err
+	}
 
-		f.Write = func(p []byte) (int, error) {
-			n, err := w.Write(p)
-			if err != nil {
-				return n, err
+	if f.group != nil {
+		return f.group.decode(buf, sv)
+	}
+
+	if f.nested() && f.s.pos {
+		rv := f.value(sv)
+		if rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				rv.Set(reflect.New(f.s.rt))
 			}
+			rv = rv.Elem()
+		}
+
+		_, err := f.s.writeAt(buf, rv, origin)
+		return err
+	}
 
-			f.rv.Set(rv.Elem())
+	n, err := f.length(buf, sv)
+	if err != nil {
+		return err
+	}
 
-			return n, nil
+	if f.ref != nil && f.ref.bytes {
+		if buf, err = buf.sub(n); err != nil {
+			return err
+		}
+		// strings and byte slices take n bytes, other values take the whole sub-buffer
+		if f.opaque() || !f.rawBytes() {
+			n = -1
 		}
+	}
+
+	if f.union != nil {
+		return f.union.decode(buf, sv, f.value(sv))
+	}
+
+	return f.Write(buf, f.value(sv), n)
+}
+
+// fieldWriter decodes field from buffer, n is the length of the field taken from its prefix or referenced field, or -1 if the length is not defined.
+type fieldWriter func(buf *buffer, rv reflect.Value, n int) error
+
+func (f *field) setWriter() (err error) {
+	if f.magic != nil {
+		f.Write = f.SetMagic
+		return nil
+	}
+
+	if f.tc != nil {
+		f.Write = f.SetRegistered
+		return nil
+	}
+
+	if reflect.PtrTo(baseType(f.rt)).Implements(writerType) {
+		f.Write = f.Writer
+		return nil
+	}
+
+	if f.binary {
+		f.Write = f.SetBinary
+		return nil
+	}
+
+	if f.enc != 0 {
+		f.Write = f.SetVarint
 		return nil
 	}
 
@@ -80,42 +174,56 @@ func (f *field) setWriter() (err error) {
 
 	case reflect.Slice:
 
-		switch f.rv.Interface().(type) {
-		case []string:
+		switch f.rt.Elem().Kind() {
+		case reflect.String:
 			f.Write = f.SetSliceString
-		// case []int, []int8, []int16, []int32, []int64:
-		// 	f.Write = f.writeSliceInt
-		// case []uint, []uint16, []uint32, []uint64:
-		// 	f.Write = f.writeSliceUint
-		case []byte:
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			f.Write = f.SetSliceInt
+		case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			f.Write = f.SetSliceUint
+		case reflect.Uint8:
 			// if f.len == 0 {
 			// 	return fmt.Errorf("Field %s type []byte should have count nums in tags: `num:\"#\"`", f.rsf.Name)
 			// }
 			f.Write = f.SetSliceByte
-		// case []bool:
-		// 	f.Write = f.writeSliceBool
+		case reflect.Bool:
+			f.Write = f.SetSliceBool
+		case reflect.Float32:
+			f.Write = f.SetSliceFloat32
+		case reflect.Float64:
+			f.Write = f.SetSliceFloat64
+		case reflect.Struct, reflect.Ptr:
+			f.Write = f.SetCustom
+			if f.s != nil {
+				f.Write = f.SetSliceStruct
+			}
 		default:
 			f.Write = f.SetCustom
-			// f.rv.Set(reflect.New(f.rv.Type()).Elem())
-			// log.Printf("%T %s\n", f.rv.Interface(), f.rv.Interface())
 			// err = fmt.Errorf("Unknown field type, %s:%T", f.rsf.Name, f.rv.Interface())
 		}
 
 	case reflect.Array:
 
-		switch f.rv.Index(0).Interface().(type) {
-		case string:
+		switch f.rt.Elem().Kind() {
+		case reflect.String:
 			f.Write = f.SetArrayString
-		// case int, int8, int16, int32, int64:
-		// 	f.Write = f.writeSliceInt
-		// case uint, uint16, uint32, uint64:
-		// 	f.Write = f.writeSliceUint
-		case byte:
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			f.Write = f.SetSliceInt
+		case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			f.Write = f.SetSliceUint
+		case reflect.Uint8:
 			f.Write = f.SetArrayByte
-			// case bool:
-			// 	f.Write = f.writeSliceBool
-			// default:
-			// err = fmt.Errorf("Unknown field type, %s:%T", f.rsf.Name, f.rv.Interface())
+		case reflect.Bool:
+			f.Write = f.SetSliceBool
+		case reflect.Float32:
+			f.Write = f.SetSliceFloat32
+		case reflect.Float64:
+			f.Write = f.SetSliceFloat64
+		case reflect.Struct, reflect.Ptr:
+			f.Write = f.SetCustom
+			if f.s != nil {
+				f.Write = f.SetSliceStruct
+			}
 		default:
 			f.Write = f.SetCustom
 		}
@@ -136,6 +244,31 @@ func (f *field) setWriter() (err error) {
 	return
 }
 
+//
+// custom writer
+
+func (f *field) Writer(buf *buffer, rv reflect.Value, n int) error {
+	ptr := reflect.New(baseType(f.rt))
+	w := ptr.Interface().(Writer)
+
+	p, err := buf.next(w.Size())
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(p); err != nil {
+		return err
+	}
+
+	if rv.Kind() == reflect.Ptr {
+		rv.Set(ptr)
+	} else {
+		rv.Set(ptr.Elem())
+	}
+
+	return nil
+}
+
 //
 // string
 
@@ -155,180 +288,430 @@ func Btos(p []byte) (_ string, n int) {
 	return string(s), n
 }
 
-func (f *field) SetString(p []byte) (n int, _ error) {
-	var s string
+// readString reads string of fixed size, or up to first 0x00 byte if size is 0.
+func readString(buf *buffer, size int) (string, error) {
+	if size == 0 {
+		p, err := buf.until(0x00)
+		return string(p), err
+	}
 
-	if f.size != 0 {
-		s, _ = Btos(p[:f.size])
-		n = f.size
-	} else {
-		s, n = Btos(p)
+	p, err := buf.next(size)
+	if err != nil {
+		return "", err
 	}
-	f.rv.SetString(s)
 
-	return
+	s, _ := Btos(p)
+	return s, nil
 }
 
-func (f *field) SetSliceString(p []byte) (n int, err error) {
+func (f *field) SetString(buf *buffer, rv reflect.Value, n int) error {
+	if n >= 0 {
+		p, err := buf.next(n)
+		if err != nil {
+			return err
+		}
+
+		rv.SetString(string(p))
+		return nil
+	}
+
+	s, err := readString(buf, f.size)
+	if err != nil {
+		return err
+	}
+
+	rv.SetString(s)
+	return nil
+}
+
+func (f *field) SetSliceString(buf *buffer, rv reflect.Value, n int) error {
 	var ss []string
-	var s string
-	var ns int
+
+	count := f.num
+	if n >= 0 {
+		count = n
+		ss = []string{}
+	}
+
+	// every string takes at least one byte, so broken count fails on short data before decoding
+	if count > 0 {
+		size := f.size
+		if size == 0 {
+			size = 1
+		}
+		if count > maxInt/size {
+			return fmt.Errorf("count %d overflows int", count)
+		}
+		if err := buf.fill(count * size); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return shortError(buf.offset(), count*size, len(buf.p)-buf.off)
+			}
+			return err
+		}
+	}
 
 	for {
-		if f.num != 0 && len(ss) >= f.num {
+		if count != 0 && len(ss) >= count {
 			break
 		}
 
-		if n >= len(p) {
+		if count == 0 && (n == 0 || !buf.more()) {
 			break
 		}
 
-		if f.size != 0 {
-			if n+f.size >= len(p) {
-				return n, io.ErrUnexpectedEOF
-			}
-
-			s, _ = Btos(p[n : n+f.size])
-			ns = f.size
-		} else {
-			s, ns = Btos(p[n:])
+		s, err := readString(buf, f.size)
+		if err != nil {
+			return err
 		}
 
 		ss = append(ss, s)
-		n += ns
 	}
 
-	f.rv.Set(reflect.ValueOf(ss))
+	rv.Set(reflect.ValueOf(ss).Convert(f.rt))
 
-	return
+	return nil
 }
 
-func (f *field) SetArrayString(p []byte) (n int, err error) {
-	var s string
-	var ns int
-
-	for i := 0; i < f.rv.Len(); i++ {
-		if f.size != 0 {
-			s, _ = Btos(p[n : n+f.size])
-			n += f.size
-		} else {
-			s, ns = Btos(p[n:])
-			n += ns
+func (f *field) SetArrayString(buf *buffer, rv reflect.Value, n int) error {
+	for i := 0; i < rv.Len(); i++ {
+		s, err := readString(buf, f.size)
+		if err != nil {
+			return err
 		}
 
-		f.rv.Index(i).SetString(s)
+		rv.Index(i).SetString(s)
 	}
 
-	return
+	return nil
 }
 
 //
 // int
 
-func (f *field) SetInt(p []byte) (int, error) {
-	f.rv.SetInt(Btoi(p[:f.size], f.e))
-	return f.size, nil
+func (f *field) SetInt(buf *buffer, rv reflect.Value, n int) error {
+	p, err := buf.next(f.size)
+	if err != nil {
+		return err
+	}
+
+	rv.SetInt(SignExtend(Btoi(p, f.e), f.size))
+	return nil
 }
 
-func (f *field) SetArrayInt(p []byte) (n int, err error) {
-	var xx []int64
-	for i := 0; i < f.rv.Len(); i++ {
-		xx = append(xx, Btoi(p[n:n+f.size], f.e))
-		n += f.size
+// SetSliceInt decodes slice or array of signed integers.
+func (f *field) SetSliceInt(buf *buffer, rv reflect.Value, n int) error {
+	p, count, err := f.elements(buf, rv, n)
+	if err != nil {
+		return err
 	}
 
-	f.rv.Set(reflect.ValueOf(xx))
+	for i := 0; i < count; i++ {
+		rv.Index(i).SetInt(SignExtend(Btoi(p[i*f.size:(i+1)*f.size], f.e), f.size))
+	}
 
-	return
+	return nil
+}
+
+// elements returns bytes of elements of numeric slice or array and their count, slice is allocated in rv.
+func (f *field) elements(buf *buffer, rv reflect.Value, n int) ([]byte, int, error) {
+	count := -1
+
+	switch {
+	case f.rk == reflect.Array:
+		count = rv.Len()
+	case n >= 0:
+		count = n
+	case f.num != 0:
+		count = f.num
+	}
+
+	var p []byte
+	var err error
+
+	if count < 0 {
+		// slice without length takes all remaining bytes
+		if p, err = buf.rest(); err != nil {
+			return nil, 0, err
+		}
+		if len(p)%f.size != 0 {
+			return nil, 0, fmt.Errorf("%d bytes is not multiple of element size %d", len(p), f.size)
+		}
+		count = len(p) / f.size
+	} else {
+		if count > maxInt/f.size {
+			return nil, 0, fmt.Errorf("count %d overflows int", count)
+		}
+		if p, err = buf.next(count * f.size); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if f.rk == reflect.Slice {
+		rv.Set(reflect.MakeSlice(f.rt, count, count))
+	}
+
+	return p, count, nil
 }
 
 //
 // uint
 
-func (f *field) SetUint(p []byte) (int, error) {
-	f.rv.SetUint(uint64(Btoi(p[:f.size], f.e)))
-	return f.size, nil
+func (f *field) SetUint(buf *buffer, rv reflect.Value, n int) error {
+	p, err := buf.next(f.size)
+	if err != nil {
+		return err
+	}
+
+	rv.SetUint(uint64(Btoi(p, f.e)))
+	return nil
+}
+
+func (f *field) SetSliceUint(buf *buffer, rv reflect.Value, n int) error {
+	p, count, err := f.elements(buf, rv, n)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		rv.Index(i).SetUint(uint64(Btoi(p[i*f.size:(i+1)*f.size], f.e)))
+	}
+
+	return nil
 }
 
 //
 // byte
 
-func (f *field) SetByte(p []byte) (int, error) {
-	f.rv.SetUint(uint64(p[0]))
-	return 1, nil
+func (f *field) SetByte(buf *buffer, rv reflect.Value, n int) error {
+	p, err := buf.next(1)
+	if err != nil {
+		return err
+	}
+
+	rv.SetUint(uint64(p[0]))
+	return nil
 }
 
-func (f *field) SetSliceByte(p []byte) (int, error) {
-	if f.len == 0 {
-		f.len = len(p)
+func (f *field) SetSliceByte(buf *buffer, rv reflect.Value, n int) (err error) {
+	var p []byte
+	if n >= 0 {
+		p, err = buf.next(n)
+	} else if f.len == 0 {
+		p, err = buf.rest()
+	} else {
+		p, err = buf.next(f.len)
+	}
+	if err != nil {
+		return err
 	}
-	f.rv.SetBytes(p[:f.len])
-	return f.len, nil
+
+	rv.SetBytes(p)
+	return nil
 }
 
-func (f *field) SetArrayByte(p []byte) (int, error) {
-	for i := 0; i < f.rv.Len(); i++ {
-		f.rv.Index(i).Set(reflect.ValueOf(p[i]))
+func (f *field) SetArrayByte(buf *buffer, rv reflect.Value, n int) error {
+	p, err := buf.next(rv.Len())
+	if err != nil {
+		return err
 	}
-	return f.rv.Len(), nil
+
+	for i := range p {
+		rv.Index(i).SetUint(uint64(p[i]))
+	}
+	return nil
 }
 
 //
 // bool
 
-func (f *field) SetBool(p []byte) (int, error) {
-	if p[0] != 0x00 {
-		f.rv.SetBool(true)
+func (f *field) SetBool(buf *buffer, rv reflect.Value, n int) error {
+	p, err := buf.next(1)
+	if err != nil {
+		return err
 	}
-	return 1, nil
+
+	rv.SetBool(p[0] != 0x00)
+	return nil
+}
+
+func (f *field) SetSliceBool(buf *buffer, rv reflect.Value, n int) error {
+	p, count, err := f.elements(buf, rv, n)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		rv.Index(i).SetBool(p[i] != 0x00)
+	}
+
+	return nil
 }
 
 //
 // float32
 
-func (f *field) SetFloat32(p []byte) (int, error) {
-	x := Btoi(p[:f.size], f.e)
+func (f *field) SetFloat32(buf *buffer, rv reflect.Value, n int) error {
+	p, err := buf.next(f.size)
+	if err != nil {
+		return err
+	}
+
+	x := Btoi(p, f.e)
 	float := math.Float32frombits(uint32(x))
-	f.rv.SetFloat(float64(float))
+	rv.SetFloat(float64(float))
 
-	return f.size, nil
+	return nil
+}
+
+func (f *field) SetSliceFloat32(buf *buffer, rv reflect.Value, n int) error {
+	p, count, err := f.elements(buf, rv, n)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		x := Btoi(p[i*f.size:(i+1)*f.size], f.e)
+		rv.Index(i).SetFloat(float64(math.Float32frombits(uint32(x))))
+	}
+
+	return nil
 }
 
 //
 // float64
 
-func (f *field) SetFloat64(p []byte) (int, error) {
-	x := Btoi(p[:f.size], f.e)
+func (f *field) SetFloat64(buf *buffer, rv reflect.Value, n int) error {
+	p, err := buf.next(f.size)
+	if err != nil {
+		return err
+	}
+
+	x := Btoi(p, f.e)
 	float := math.Float64frombits(uint64(x))
-	f.rv.SetFloat(float)
+	rv.SetFloat(float)
 
-	return f.size, nil
+	return nil
+}
+
+func (f *field) SetSliceFloat64(buf *buffer, rv reflect.Value, n int) error {
+	p, count, err := f.elements(buf, rv, n)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		x := Btoi(p[i*f.size:(i+1)*f.size], f.e)
+		rv.Index(i).SetFloat(math.Float64frombits(uint64(x)))
+	}
+
+	return nil
 }
 
-//
 // struct
-func (f *field) SetStruct(p []byte) (n int, _ error) {
-	for _, subf := range f.s.fields {
-		nw, err := subf.Write(p[n:])
-		if err != nil {
-			return n, err
+func (f *field) SetStruct(buf *buffer, rv reflect.Value, n int) error {
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(reflect.New(f.s.rt))
+		}
+		rv = rv.Elem()
+	}
+
+	_, err := f.s.write(buf, rv)
+	return err
+}
+
+func (f *field) SetSliceStruct(buf *buffer, rv reflect.Value, n int) error {
+	count := f.num
+	if n >= 0 {
+		count = n
+	}
+
+	// slice without length takes all remaining bytes
+	all := count == 0 && n < 0
+
+	// elements of slice are appended one by one, so broken count fails on short data instead of allocating
+	sv := rv
+	if f.rk == reflect.Slice {
+		sv = reflect.MakeSlice(f.rt, 0, 0)
+	}
+
+	ptr := f.rt.Elem().Kind() == reflect.Ptr
+
+	for i := 0; all && buf.more() || !all && i < count; i++ {
+		offset := buf.offset()
+
+		if f.rk == reflect.Slice {
+			sv = reflect.Append(sv, reflect.Zero(f.rt.Elem()))
+		}
+
+		ev := sv.Index(i)
+		if ptr {
+			if ev.IsNil() {
+				ev.Set(reflect.New(f.s.rt))
+			}
+			ev = ev.Elem()
+		}
+
+		if _, err := f.s.write(buf, ev); err != nil {
+			return fieldError(err, fmt.Sprintf("[%d]", i), offset)
+		}
+
+		// element without bytes would be decoded endlessly
+		if all && buf.offset() == offset {
+			return fieldError(fmt.Errorf("element of %s takes no bytes", f.rt), fmt.Sprintf("[%d]", i), offset)
 		}
-		n += nw
 	}
-	return n, nil
+
+	rv.Set(sv)
+	return nil
 }
 
 //
 // custom types
 
-func (f *field) SetCustom(p []byte) (n int, err error) {
-	count := f.num
-	if count == 0 {
-		count = int(f.rv.Type().Size())
+func (f *field) SetCustom(buf *buffer, rv reflect.Value, n int) error {
+	var p []byte
+	var err error
+
+	switch {
+	case n >= 0:
+		esize := int(f.rt.Elem().Size())
+		if esize != 0 && n > maxInt/esize {
+			return fmt.Errorf("count %d overflows int", n)
+		}
+		p, err = buf.next(n * esize)
+	case f.num != 0:
+		p, err = buf.next(f.num)
+	case f.rk == reflect.Slice:
+		p, err = buf.rest()
+	default:
+		p, err = buf.next(int(f.rt.Size()))
+	}
+	if err != nil {
+		return err
+	}
+	count := len(p)
+
+	if f.rk == reflect.Slice {
+		var l int
+		if esize := int(f.rt.Elem().Size()); esize != 0 {
+			l = count / esize
+		}
+		rv.Set(reflect.MakeSlice(f.rt, l, l))
+	}
+
+	copy(rawBytes(rv), p)
+	return nil
+}
+
+// SignExtend extends sign of integer x stored in size bytes.
+func SignExtend(x int64, size int) int64 {
+	if size <= 0 || size >= 8 {
+		return x
 	}
 
-	f.rv.Set(reflect.ValueOf(p[:count]))
-	return count, nil
+	shift := uint(64 - size*8)
+	return x << shift >> shift
 }
 
 func Btoi(p []byte, e ByteOrder) (x int64) {
@@ -1,9 +1,8 @@
 package stob
 
 import (
-	"io/ioutil"
+	"fmt"
 	"reflect"
-	"strconv"
 )
 
 type ByteOrder string
@@ -14,67 +13,262 @@ var (
 	BigEndian     ByteOrder = "be"
 )
 
+// Struct binds compiled codec to the value of struct.
 type Struct struct {
 	rv reflect.Value
+	c  *Codec
+}
+
+func NewStruct(x interface{}) (*Struct, error) {
+	return defaultOptions.NewStruct(x)
+}
+
+// Codec is the encoding plan of the struct type, it is not bound to any value.
+type Codec struct {
 	rt reflect.Type
 
 	fields []*field
-}
+	len    int
 
-func NewStruct(x interface{}) (*Struct, error) {
-	return newStruct(reflect.ValueOf(x).Elem())
+	// refs is true if some fields refer to lengths or discriminators in other fields
+	refs bool
+
+	// tail is true if the last field takes all remaining bytes on decoding
+	tail bool
+
+	// unexported is true if struct has unexported fields, they are accessed by unsafe pointers
+	unexported bool
+
+	// sums is true if struct has checksum fields
+	sums bool
+
+	// pos is true if struct or its nested structs have fields with offset or at tags, their positions are taken from the start of the top-level struct
+	pos bool
+
+	// align is alignment of struct if fields are aligned as C compiler does, struct is padded to it
+	align int
+
+	o *Options
 }
 
-func newStruct(rv reflect.Value) (*Struct, error) {
-	s := new(Struct)
-	s.rv = rv
-	s.rt = rv.Type()
+// newCodec compiles struct type rt, e is byte order inherited from struct field or empty.
+func newCodec(rt reflect.Type, o *Options, e ByteOrder, parents []codecKey) (*Codec, error) {
+	if rt.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("stob: %s is not a struct", rt)
+	}
+
+	c := new(Codec)
+	c.rt = rt
+	c.o = o
+
+	var group *field
+
+	unexported := exportAll(rt, o)
+
+	for i := 0; i < rt.NumField(); i++ {
+		rsf := rt.Field(i)
+
+		// exported fields of embedded struct of unexported type are promoted, so it is encoded as encoding/json does
+		embedded := rsf.Anonymous && baseType(rsf.Type).Kind() == reflect.Struct
 
-	for i := 0; i < s.rv.NumField(); i++ {
-		f, ok, err := newField(s.rv.Field(i), s.rt.Field(i))
+		if !rsf.IsExported() && rsf.Name != "_" && !unexported && !embedded {
+			continue
+		}
+
+		f, ok, err := newField(rsf, i, o, e, parents)
 		if err != nil {
-			return s, err
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		if f.unexported {
+			c.unexported = true
 		}
-		if ok {
-			s.fields = append(s.fields, f)
+
+		if f.bits != 0 {
+			if group == nil || !group.group.add(f) {
+				group = newBitGroup(f)
+				group.group.add(f)
+
+				c.fields = append(c.fields, group)
+			} else if f.pad != 0 || f.align != 0 {
+				return nil, fmt.Errorf("stob: pad and align tags of bit field %s are allowed only for the first field of group", f.rsf.Name)
+			}
+			continue
+		}
+		group = nil
+
+		c.fields = append(c.fields, f)
+	}
+
+	for _, f := range c.fields {
+		if f.pos != nil || f.nested() && f.s.pos {
+			c.pos = true
 		}
 	}
 
-	return s, nil
+	if err := c.layout(); err != nil {
+		return nil, err
+	}
+
+	for i, f := range c.fields {
+		if f.unbounded() {
+			if i < len(c.fields)-1 && !o.Lenient {
+				return nil, fmt.Errorf("stob: field %s takes all remaining bytes, but it is not the last field, it should have num, prefix or len tag", f.rsf.Name)
+			}
+			c.tail = i == len(c.fields)-1
+		}
+
+		if f.ref != nil {
+			if err := f.ref.resolve(rt, f); err != nil {
+				return nil, err
+			}
+			c.refs = true
+		}
+
+		if f.sum != nil {
+			if err := f.sum.resolve(c, f); err != nil {
+				return nil, err
+			}
+			c.sums = true
+		}
+
+		if f.pos != nil && f.pos.ref != nil {
+			if err := f.pos.ref.resolve(rt, f); err != nil {
+				return nil, err
+			}
+		}
+
+		if f.cond != nil {
+			if err := f.cond.resolve(rt, f); err != nil {
+				return nil, err
+			}
+		}
+
+		if f.union != nil {
+			if err := f.union.resolve(rt, f); err != nil {
+				return nil, err
+			}
+			c.refs = true
+		}
+	}
+
+	return c, nil
 }
 
 type field struct {
-	rv  reflect.Value
-	rsf reflect.StructField
-	rk  reflect.Kind
+	rsf   reflect.StructField
+	rt    reflect.Type
+	rk    reflect.Kind
+	index int
 
 	num  int
 	size int
 	len  int
 	e    ByteOrder
 
+	// bo is byte order inherited by fields of nested struct, it is empty if field and its parents do not have bo tag
+	bo ByteOrder
+
+	// parents are codecs being compiled when field is created
+	parents []codecKey
+
+	prefix *lengthPrefix
+	ref    *lengthRef
+
+	// enc is variable-length encoding of integer
+	enc varEnc
+
+	// magic is encoded constant of field, magicValue is its value set on decoding
+	magic      []byte
+	magicValue reflect.Value
+
+	// unexported is true if field is not exported, see value
+	unexported bool
+
+	// reserved is count of bytes of reserved field, fill is their value
+	reserved int
+	fill     byte
+
+	// sum is checksum stored in field
+	sum *checksum
+
+	// pos is offset of field in struct
+	pos *position
+
+	// pad is count of zero bytes before field, align is alignment of field offset in struct
+	pad   int
+	align int
+
+	// bit field width and its position in bit group
+	bits  int
+	shift int
+	lsb   bool
+	group *bitGroup
+
+	// binary is true if value is encoded by its standard marshaling methods
+	binary bool
+
+	// tc is registered codec of field type
+	tc  TypeCodec
+	tag Tag
+
+	cond  *condition
+	union *union
+
 	Read  fieldReader
 	Write fieldWriter
 
-	s *Struct
+	s *Codec
+	o *Options
 }
 
-func newField(rv reflect.Value, rsf reflect.StructField) (f *field, ok bool, err error) {
-	if !rv.CanSet() {
+func newField(rsf reflect.StructField, index int, o *Options, e ByteOrder, parents []codecKey) (f *field, ok bool, err error) {
+	// blank fields are reserved bytes or constants, unless they are markers
+	if isMarker(rsf) {
 		return nil, false, nil
 	}
 
 	f = new(field)
-	f.rv = rv
 	f.rsf = rsf
-	f.rk = rv.Kind()
-
-	if ok = f.readTag(rsf.Tag); !ok {
+	f.unexported = !rsf.IsExported() && rsf.Name != "_"
+	f.rt = rsf.Type
+	f.rk = rsf.Type.Kind()
+	f.index = index
+	f.o = o
+	f.parents = parents
+	f.tc = o.typeCodec(baseType(f.rt))
+	f.binary = f.tc == nil && isBinary(f.rt)
+
+	if ok, err = f.readTag(rsf.Tag, e); !ok || err != nil {
 		return
 	}
 
+	if f.tc != nil {
+		if err = f.initTypeCodec(rsf.Tag); err != nil {
+			return
+		}
+	}
+
+	if !o.Lenient {
+		if err = f.validate(rsf.Tag); err != nil {
+			return
+		}
+	}
+
 	f.lookupSizes()
 
+	if err = f.initMagic(rsf.Tag); err != nil {
+		return
+	}
+
+	if f.reserved != 0 || rsf.Name == "_" && f.magic == nil && f.bits == 0 {
+		err = f.initReserved(rsf.Tag)
+		return
+	}
+
 	if err = f.setReader(); err != nil {
 		return
 	}
@@ -83,36 +277,175 @@ func newField(rv reflect.Value, rsf reflect.StructField) (f *field, ok bool, err
 		return
 	}
 
-	if f.rk == reflect.Struct || f.rk == reflect.Ptr {
-		f.lookupStructSizes()
+	if (f.rk == reflect.Struct || f.rk == reflect.Ptr || f.s != nil) && !f.opaque() {
+		err = f.lookupStructSizes()
 	}
 
 	return
 }
 
-func (f *field) readTag(tag reflect.StructTag) bool {
+func (f *field) readTag(tag reflect.StructTag, e ByteOrder) (bool, error) {
 	if tag.Get("stob") == "-" {
-		return false
+		return false, nil
 	}
 
+	// byte order of struct field is inherited by fields of nested struct
 	f.e = DefaultEndian
-	f.e = ByteOrder(tag.Get("bo"))
+	if e != "" {
+		f.e, f.bo = e, e
+	}
+	if bo := tag.Get("bo"); bo != "" {
+		f.e, f.bo = ByteOrder(bo), ByteOrder(bo)
+	}
+
+	var err error
+	if f.size, err = tagInt(tag, "size"); err != nil && !f.o.Lenient {
+		return true, fmt.Errorf("stob: field %s: %s", f.rsf.Name, err)
+	}
+	if f.num, err = tagInt(tag, "num"); err != nil && !f.o.Lenient {
+		return true, fmt.Errorf("stob: field %s: %s", f.rsf.Name, err)
+	}
+	if f.reserved, err = tagInt(tag, "reserved"); err != nil && !f.o.Lenient {
+		return true, fmt.Errorf("stob: field %s: %s", f.rsf.Name, err)
+	}
+	if f.pad, err = tagInt(tag, "pad"); err != nil && !f.o.Lenient {
+		return true, fmt.Errorf("stob: field %s: %s", f.rsf.Name, err)
+	}
+	if f.align, err = tagAlign(tag, "align"); err != nil && !f.o.Lenient {
+		return true, fmt.Errorf("stob: field %s: %s", f.rsf.Name, err)
+	}
+
+	if prefix := tag.Get("prefix"); prefix != "" {
+		if f.rk != reflect.String && f.rk != reflect.Slice && !f.opaque() {
+			return true, fmt.Errorf("stob: prefix tag of field %s is allowed only for strings and slices", f.rsf.Name)
+		}
+
+		lp, err := parsePrefix(prefix, f.e)
+		if err != nil {
+			return true, fmt.Errorf("stob: field %s: %s", f.rsf.Name, err)
+		}
+
+		f.prefix = lp
+		f.num = 0
+	}
+
+	if enc := tag.Get("enc"); enc != "" {
+		v, err := parseVarEnc(enc)
+		if err != nil {
+			return true, fmt.Errorf("stob: field %s: %s", f.rsf.Name, err)
+		}
+
+		switch f.rk {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			f.enc = v
+
+		case reflect.String, reflect.Slice:
+			// encoding of length prefix
+			if f.prefix != nil {
+				return true, fmt.Errorf("stob: field %s has both prefix and enc tags", f.rsf.Name)
+			}
+			f.prefix = &lengthPrefix{enc: v}
+			f.num = 0
+
+		default:
+			return true, fmt.Errorf("stob: enc tag of field %s is allowed only for integers, strings and slices", f.rsf.Name)
+		}
+	}
+
+	if bits := tag.Get("bits"); bits != "" {
+		if err := f.parseBits(bits); err != nil {
+			return true, err
+		}
+	}
+
+	if cs := tag.Get("checksum"); cs != "" {
+		if err := f.parseChecksum(cs); err != nil {
+			return true, err
+		}
+	}
+
+	if err := f.parsePosition(tag); err != nil {
+		return true, err
+	}
+
+	if expr := tag.Get("if"); expr != "" {
+		if f.bits != 0 {
+			return true, fmt.Errorf("stob: bit field %s can not be conditional", f.rsf.Name)
+		}
+
+		cond, err := parseCondition(expr)
+		if err != nil {
+			return true, fmt.Errorf("stob: field %s: %s", f.rsf.Name, err)
+		}
+
+		f.cond = cond
+	}
 
-	f.size, _ = strconv.Atoi(tag.Get("size"))
-	f.num, _ = strconv.Atoi(tag.Get("num"))
+	for _, name := range []string{"len", "count"} {
+		ref := tag.Get(name)
+		if ref == "" {
+			continue
+		}
+
+		if f.rk != reflect.String && f.rk != reflect.Slice && !((f.rk == reflect.Interface || f.opaque()) && name == "len") {
+			return true, fmt.Errorf("stob: %s tag of field %s is allowed only for strings and slices", name, f.rsf.Name)
+		}
+
+		if f.sized() {
+			return true, fmt.Errorf("stob: field %s has more than one length tag", f.rsf.Name)
+		}
+
+		lr, err := parseRef(ref, name == "len")
+		if err != nil {
+			return true, fmt.Errorf("stob: field %s: %s", f.rsf.Name, err)
+		}
+
+		f.ref = lr
+		f.num = 0
+	}
+
+	if name := tag.Get("switch"); name != "" {
+		if f.rk != reflect.Interface {
+			return true, fmt.Errorf("stob: switch tag of field %s is allowed only for interfaces", f.rsf.Name)
+		}
+
+		f.union = &union{name: name, it: f.rt, o: f.o}
+	}
 
-	return true
+	return true, nil
 }
 
 func (f *field) lookupSizes() {
+	if f.opaque() {
+		f.len = f.size
+		if f.prefix != nil {
+			f.len = f.prefix.size
+		}
+		if f.ref != nil {
+			f.len = 0
+		}
+		return
+	}
+
 	if f.size == 0 {
-		if f.rk != reflect.String && f.rk != reflect.Slice && f.rk != reflect.Array {
-			f.size = int(f.rv.Type().Size())
+		switch f.rk {
+		case reflect.String:
+		case reflect.Slice, reflect.Array:
+			// size of numeric elements
+			switch f.rt.Elem().Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+				reflect.Bool, reflect.Float32, reflect.Float64:
+				f.size = int(f.rt.Elem().Size())
+			}
+		default:
+			f.size = int(f.rt.Size())
 		}
 	}
 
 	if f.num == 0 && f.rk == reflect.Array {
-		f.num = f.rv.Len()
+		f.num = f.rt.Len()
 	}
 
 	f.len = f.size
@@ -123,51 +456,83 @@ func (f *field) lookupSizes() {
 	if f.num != 0 && f.size != 0 {
 		f.len = f.num * f.size
 	}
+
+	if f.rk == reflect.Slice && f.num == 0 {
+		// length of slice is not known
+		f.len = 0
+	}
+
+	if f.prefix != nil {
+		f.len = f.prefix.size
+	}
+
+	if f.ref != nil || f.enc != 0 {
+		f.len = 0
+	}
 }
 
 func (f *field) lookupStructSizes() (err error) {
-	if s, ok := f.rv.Interface().(Size); ok {
-		f.len = s.Size()
+	if f.rk == reflect.Slice || f.rk == reflect.Array {
+		if !f.sized() {
+			f.len = f.num * f.s.len
+		}
+		return nil
+	}
+
+	if n, ok := typeSize(f.rt); ok {
+		f.len = n
 		return
 	}
 
 	if f.s == nil {
-		f.s, err = newStruct(f.rv)
+		rt := f.rt
+		if rt.Kind() == reflect.Ptr {
+			rt = rt.Elem()
+		}
+
+		f.s, err = f.compile(rt)
 		if err != nil {
 			return err
 		}
 	}
 
-	var structLen int
+	f.len = f.s.len
 
-	for _, subf := range f.s.fields {
-		structLen += subf.len
+	return nil
+}
+
+// typeSize returns size reported by type implemented Size interface.
+func typeSize(rt reflect.Type) (int, bool) {
+	if rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
 	}
 
-	f.len = structLen
+	if s, ok := reflect.New(rt).Interface().(Size); ok {
+		return s.Size(), true
+	}
 
-	return nil
+	return 0, false
 }
 
 //
 //
 //
 
+// Marshal encodes struct x, generated MarshalStob method is used if x has it.
 func Marshal(x interface{}) ([]byte, error) {
-	s, err := NewStruct(x)
-	if err != nil {
-		return nil, err
+	if m, ok := x.(Marshaler); ok {
+		return m.MarshalStob(make([]byte, 0, m.StobSize()))
 	}
 
-	return ioutil.ReadAll(s)
+	return defaultOptions.Marshal(x)
 }
 
+// Unmarshal decodes data to struct x, generated UnmarshalStob method is used if x has it.
 func Unmarshal(data []byte, x interface{}) error {
-	s, err := NewStruct(x)
-	if err != nil {
+	if u, ok := x.(Unmarshaler); ok {
+		_, err := u.UnmarshalStob(data)
 		return err
 	}
 
-	_, err = s.Write(data)
-	return err
+	return defaultOptions.Unmarshal(data, x)
 }
@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"math"
 	"math/rand"
 	"net"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -124,7 +127,7 @@ func TestWriteRead(t *testing.T) {
 
 	p := make([]byte, 128)
 	nr, err := s.Read(p)
-	if err != nil {
+	if err != nil && err != io.EOF {
 		t.Error(err, nr)
 	}
 
@@ -193,6 +196,266 @@ func TestItob(t *testing.T) {
 	// fmt.Println(hex.Dump(p))
 }
 
+type NumericStruct struct {
+	I8   []int8    `num:"2"`
+	I16  []int16   `num:"2" bo:"be"`
+	I32  [2]int32  `size:"3"`
+	I64  []int64   `prefix:"u8"`
+	Int  [1]int    `bo:"be"`
+	U16  [2]uint16 `bo:"be"`
+	U32  []uint32  `num:"3" size:"2"`
+	U64  [1]uint64
+	Uint []uint `num:"1"`
+	B    [3]bool
+	Bs   []bool     `prefix:"u8"`
+	F32  [2]float32 `bo:"be"`
+	F64s []float64  `num:"2"`
+	Tail []uint16   `bo:"be"`
+}
+
+func TestNumericSlices(t *testing.T) {
+	a := NumericStruct{
+		I8:   []int8{-1, 2},
+		I16:  []int16{-2, 0x0102},
+		I32:  [2]int32{-3, 0x010203},
+		I64:  []int64{-4},
+		Int:  [1]int{-5},
+		U16:  [2]uint16{0x0102, 0xfffe},
+		U32:  []uint32{1, 0xffff, 3},
+		U64:  [1]uint64{1 << 63},
+		Uint: []uint{7},
+		B:    [3]bool{true, false, true},
+		Bs:   []bool{false, true},
+		F32:  [2]float32{1.5, -2.25},
+		F64s: []float64{0.1, -1e100},
+		Tail: []uint16{1, 2, 3},
+	}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []byte{
+		0xff, 0x02,
+		0xff, 0xfe, 0x01, 0x02,
+		0xfd, 0xff, 0xff, 0x03, 0x02, 0x01,
+		0x01, 0xfc, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfb,
+		0x01, 0x02, 0xff, 0xfe,
+		0x01, 0x00, 0xff, 0xff, 0x03, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80,
+		0x07, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x01,
+		0x02, 0x00, 0x01,
+		0x3f, 0xc0, 0x00, 0x00, 0xc0, 0x10, 0x00, 0x00,
+	}
+	p := make([]byte, 8)
+	for _, x := range a.F64s {
+		Itob(p, int64(math.Float64bits(x)), LittleEndian)
+		expect = append(expect, p...)
+	}
+	expect = append(expect, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03)
+
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	var b NumericStruct
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(b, a) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", b, a)
+	}
+
+	// missing elements are encoded as zeros, extra elements are dropped
+	c := NumericStruct{I8: []int8{1}, I16: []int16{1, 2, 3}}
+	if data, err = Marshal(&c); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[:6], []byte{0x01, 0x00, 0x00, 0x01, 0x00, 0x02}) {
+		t.Errorf("unexpected data % 02x", data[:6])
+	}
+
+	// tail is not multiple of element size
+	if err := Unmarshal(append(expect, 0x04), &b); err == nil {
+		t.Error("odd tail is decoded")
+	}
+}
+
+type RecordFile struct {
+	Magic   [2]byte
+	Count   uint16 `bo:"be"`
+	Fixed   [2]SubCodecStruct
+	Ptrs    [2]*SubCodecStruct
+	Num     []*SubCodecStruct `num:"2"`
+	Records []SubCodecStruct  `count:"Count"`
+	Rest    []*SubCodecStruct
+}
+
+func TestStructSlices(t *testing.T) {
+	a := RecordFile{
+		Magic:   [2]byte{'R', 'F'},
+		Fixed:   [2]SubCodecStruct{{A: 1, B: 2}, {A: 3, B: 4}},
+		Ptrs:    [2]*SubCodecStruct{{A: 5, B: 6}, {A: 7, B: 8}},
+		Num:     []*SubCodecStruct{{A: 9, B: 10}, {A: 11, B: 12}},
+		Records: []SubCodecStruct{{A: 13, B: 14}},
+		Rest:    []*SubCodecStruct{{A: 15, B: 16}, {A: 17, B: 18}},
+	}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []byte{'R', 'F', 0x00, 0x01}
+	for i := byte(1); i < 18; i += 2 {
+		expect = append(expect, i, 0x00, i+1)
+	}
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	var b RecordFile
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	a.Count = 1
+	if !reflect.DeepEqual(b, a) {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", b, a)
+	}
+
+	// nil pointers and missing elements are encoded as zero structs
+	c := RecordFile{Num: []*SubCodecStruct{nil}}
+	if data, err = Marshal(&c); err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 4+6*3 {
+		t.Errorf("unexpected data % 02x", data)
+	}
+
+	s, err := NewStruct(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.c.len != 4+6*3 {
+		t.Errorf("unexpected size %d", s.c.len)
+	}
+}
+
+func TestStructSlicesEmpty(t *testing.T) {
+	var a struct {
+		A []struct{}
+	}
+
+	if err := Unmarshal(nil, &a); err == nil || !strings.Contains(err.Error(), "without encoded fields") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if err := Lenient.Unmarshal([]byte{1, 2, 3}, &a); err == nil || !strings.Contains(err.Error(), "takes no bytes") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if err := Lenient.Unmarshal(nil, &a); err != nil || len(a.A) != 0 {
+		t.Errorf("unexpected slice %v, %v", a.A, err)
+	}
+}
+
+type NarrowStruct struct {
+	A int16  `size:"2"`
+	B int32  `size:"3" bo:"be"`
+	C int64  `size:"5"`
+	D uint32 `size:"3"`
+	E int    `size:"1"`
+}
+
+func TestSignExtend(t *testing.T) {
+	a := NarrowStruct{A: -1, B: -2, C: -1 << 39, D: 0xffffff, E: -128}
+
+	data, err := Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []byte{0xff, 0xff, 0xff, 0xff, 0xfe, 0x00, 0x00, 0x00, 0x00, 0x80, 0xff, 0xff, 0xff, 0x80}
+	if !bytes.Equal(data, expect) {
+		t.Errorf("unexpected data\n% 02x\n% 02x", data, expect)
+	}
+
+	var b NarrowStruct
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b != a {
+		t.Errorf("decoded struct is not equal\n%+v\n%+v", b, a)
+	}
+
+	for _, test := range []struct {
+		x, expect int64
+		size      int
+	}{
+		{0x7f, 0x7f, 1},
+		{0x80, -128, 1},
+		{0xffffff, -1, 3},
+		{0x7fffff, 0x7fffff, 3},
+		{-1, -1, 8},
+	} {
+		if x := SignExtend(test.x, test.size); x != test.expect {
+			t.Errorf("SignExtend(%#x, %d) = %d, expected %d", test.x, test.size, x, test.expect)
+		}
+	}
+}
+
+func TestCheckOverflow(t *testing.T) {
+	o := &Options{CheckOverflow: true}
+
+	for _, a := range []NarrowStruct{
+		{A: -32768, B: 1<<23 - 1, C: -1 << 39, D: 1<<24 - 1, E: 127},
+		{B: -1 << 23, C: 1<<39 - 1, E: -128},
+	} {
+		if _, err := o.Marshal(&a); err != nil {
+			t.Errorf("%+v: %v", a, err)
+		}
+	}
+
+	for _, test := range []struct {
+		a    NarrowStruct
+		path string
+	}{
+		{NarrowStruct{B: 1 << 23}, "B"},
+		{NarrowStruct{B: -1<<23 - 1}, "B"},
+		{NarrowStruct{C: 1 << 39}, "C"},
+		{NarrowStruct{D: 1 << 24}, "D"},
+		{NarrowStruct{E: 128}, "E"},
+	} {
+		_, err := o.Marshal(&test.a)
+		if fe, ok := err.(*FieldError); !ok || fe.Path != test.path {
+			t.Errorf("%+v: unexpected error %v", test.a, err)
+		}
+
+		// values are truncated by default
+		if _, err := Marshal(&test.a); err != nil {
+			t.Error(err)
+		}
+	}
+
+	type slice struct {
+		A []int16  `num:"2" size:"1"`
+		B []uint32 `num:"2" size:"2"`
+	}
+
+	if _, err := o.Marshal(&slice{A: []int16{1, -1}, B: []uint32{1, 0xffff}}); err != nil {
+		t.Error(err)
+	}
+	if _, err := o.Marshal(&slice{A: []int16{1, 200}, B: []uint32{1, 2}}); err == nil {
+		t.Error("overflow of slice element is not detected")
+	}
+	if _, err := o.Marshal(&slice{A: []int16{1, 2}, B: []uint32{1, 0x10000}}); err == nil {
+		t.Error("overflow of slice element is not detected")
+	}
+}
+
 func BenchmarkRead(b *testing.B) {
 	b.StopTimer()
 	a := YourStruct{